@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"github.com/a696385/go-meter/meter"
 	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"strings"
@@ -19,102 +22,297 @@ var (
 	_connection     = flag.Int("c", 64, "Connections count")
 	_threads        = flag.Int("t", 4, "Threads count")
 	_mrq            = flag.Int("mrq", -1, "Max request per second")
-	_source         = flag.String("s", "", "POST/PUT Body source file with \"\\n\" delimeter or URLs on GET/DELETE")
+	_rate           = flag.Int("rate", 0, "Constant arrival rate per second, requests are sent independent of responses")
+	_source         = flag.String("s", "", "POST/PUT Body source file with \"\\n\" delimeter or URLs on GET/DELETE, *.jsonl for structured requests, *.har for recorded sessions")
 	_duration       = flag.Duration("d", time.Duration(30)*time.Second, "Test duration")
+	_requests       = flag.Int("n", 0, "Stop after total responses count, -d is unlimited if not set")
+	_perConnection  = flag.Int("per-conn", 0, "Max requests count of every connection, test stops when all connections are used")
+	_stages         = flag.String("stages", "", "Load profile stages like 30s:100rps,2m:1000rps:50c,30s:0, overrides -d and -mrq")
+	_connectTimeout = flag.Duration("connect-timeout", 0, "TCP connect and TLS handshake timeout, 0 for unlimited")
+	_writeTimeout   = flag.Duration("write-timeout", 0, "Request write timeout, 0 for unlimited")
+	_ttfbTimeout    = flag.Duration("ttfb-timeout", 0, "Time to first response byte timeout, 0 for unlimited")
+	_timeout        = flag.Duration("timeout", 0, "Total response read timeout, 0 for unlimited")
+	_drainTimeout   = flag.Duration("drain-timeout", meter.DefaultDrainTimeout, "Wait for in flight responses after test is stopped, 0 to not wait")
 	_verbose        = flag.Bool("v", false, "Live stats view")
+	_precision      = flag.Int("precision", meter.DefaultHistogramPrecision, "Latency histogram significant digits 1..5")
+	_reconnect      = flag.Bool("reconnect", false, "Reconnect on every request")
 	_excludeSeconds = flag.Duration("es", time.Duration(0)*time.Second, "Exclude first seconds from stats")
 	_help           = flag.Bool("h", false, "Help")
+	_scenario       = flag.String("config", "", "Scenario file *.yaml or *.json, flags override file values")
+	_dumpConfig     = flag.Bool("dump-config", false, "Print effective scenario of file and flags and exit")
 	_cpuprofile     = flag.String("cpuprofile", "", "write cpu profile to file")
+	_output         = flag.String("o", "", "Report format: text, json. Default is json for -out *.json, text otherwise")
+	_outFile        = flag.String("out", "", "Write report to file")
+	_csvFile        = flag.String("csv", "", "Write per second stats to CSV file")
+	_thresholds     meter.ThresholdsFlag
+	_metricsAddr    = flag.String("metrics-addr", "", "Serve Prometheus metrics on address like :9100")
+	_agent          = flag.String("agent", "", "Agent mode: listen controller on address like :7070 (loopback), use host 0.0.0.0 with -agent-token and -agent-cert for remote controllers")
+	_agents         = flag.String("agents", "", "Controller mode: comma separated agents addresses, rates are split between agents")
+	_agentToken     = flag.String("agent-token", "", "Shared secret of agent and controller, required when agent listens on non-loopback address")
+	_agentCert      = flag.String("agent-cert", "", "Agent mode: TLS certificate file of agent, required with -agent-key on non-loopback address")
+	_agentKey       = flag.String("agent-key", "", "Agent mode: TLS key file of agent")
+	_agentCA        = flag.String("agent-ca", "", "Controller mode: CA bundle of agents certificates, enables TLS link required for non-loopback agents")
+	_search         = flag.String("search", "", "Capacity search mode: step, binary")
+	_searchStart    = flag.Int("search-start", 100, "Capacity search: first rate per second")
+	_searchStep     = flag.Int("search-step", 100, "Capacity search: rate increment in step mode, precision in binary mode")
+	_searchMax      = flag.Int("search-max", 0, "Capacity search: max rate per second, required in binary mode")
+	_searchWindow   = flag.Duration("search-window", time.Duration(10)*time.Second, "Capacity search: test time of every rate")
+	_searchSLO      meter.ThresholdsFlag
+	_headers        = meter.HeadersFlag{}
+	_groups         meter.GroupsFlag
+	_harHost        = flag.String("har-host", "", "HAR source: comma separated hosts to replay")
+	_harPath        = flag.String("har-path", "", "HAR source: regexp of URL paths to replay")
+	_harMethod      = flag.String("har-method", "", "HAR source: comma separated methods to replay")
+	_harNoCookies   = flag.Bool("har-strip-cookies", false, "HAR source: remove Cookie headers")
+	_template       = flag.Bool("template", false, "Enable {{placeholders}} in URL, headers and body")
+	_vars           = flag.String("vars", "", "CSV file with header line for {{var COLUMN}} template values")
+	_headersFile    = flag.String("headers", "", "Headers file with \"Name: value\" lines")
+	_tlsServerName  = flag.String("tls-sni", "", "TLS server name, default is URL host")
+	_tlsInsecure    = flag.Bool("tls-insecure", false, "Skip TLS certificate verification")
+	_tlsCA          = flag.String("tls-ca", "", "TLS CA bundle file")
+	_tlsCert        = flag.String("tls-cert", "", "TLS client certificate file")
+	_tlsKey         = flag.String("tls-key", "", "TLS client key file")
+	_tlsMin         = flag.String("tls-min", "", "Min TLS version: 1.0, 1.1, 1.2, 1.3")
+	_tlsMax         = flag.String("tls-max", "", "Max TLS version: 1.0, 1.1, 1.2, 1.3")
+	_tlsCiphers     = flag.String("tls-ciphers", "", "Comma separated TLS cipher suites")
 )
 
-type RequestStats struct {
-	ResponseCode int
-	Duration     time.Duration
-	NetIn        int64
-	NetOut       int64
+func init() {
+	flag.Var(_headers, "H", "Request header \"Name: value\", can be repeated, overrides -headers file")
+	flag.Var(&_thresholds, "assert", "Threshold like p99<200ms, errors<0.1%, rps>5000, status:5xx<1%, can be repeated")
+	flag.Var(&_groups, "group", "Weighted request group name:weight:source like search:70:search.txt, source is -s file, can be repeated")
+	flag.Var(&_searchSLO, "search-slo", "Capacity search: threshold of sustainable rate like p99<200ms, can be repeated")
 }
 
-type Config struct {
-	Method            string
-	Host              string
-	Url               *url.URL
-	Connections       int
-	Threads           int
-	MRQ               int
-	Verbose           bool
-	ExcludeSeconds    time.Duration
-	Source            *Source
-	Duration          time.Duration
-	ConnectionManager *ConnectionManager
-	WorkerQuit        chan bool
-	WorkerQuited      chan bool
-	StatsQuit         chan bool
-	StatsQuited       chan bool
-	RequestStats      chan *RequestStats
+func main() {
+	os.Exit(run())
 }
 
-func main() {
+//Run test, returns process exit code
+func run() int {
 	flag.Parse()
 
 	if *_help {
 		flag.Usage()
-		return
+		return 0
+	}
+
+	if *_agent != "" {
+		var agentTLS *meter.TLSOptions
+		if *_agentCert != "" || *_agentKey != "" {
+			agentTLS = &meter.TLSOptions{CertFile: *_agentCert, KeyFile: *_agentKey}
+		}
+		if err := meter.RunAgent(*_agent, *_agentToken, agentTLS, os.Stdout); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		return 0
 	}
 
 	var (
-		sourceData *Source
+		sourceData *meter.Source
+		scenario   *Scenario
 		err        error
 	)
 
-	*_method = strings.ToUpper(*_method)
-
-	if *_method == "POST" || *_method == "PUT" || (len(*_source) > 0 && FileExists(*_source)) {
-		sourceData, err = LoadSource(*_source, "\n")
+	if *_scenario != "" {
+		if scenario, err = LoadScenario(*_scenario); err != nil {
+			fmt.Printf("ERROR: Can not load scenario file %s %v\n", *_scenario, err)
+			return 1
+		}
+		if err = scenario.Apply(); err != nil {
+			fmt.Printf("ERROR: Scenario file %s %v\n", *_scenario, err)
+			return 1
+		}
+	}
+	if *_dumpConfig {
+		if scenario, err = flagsScenario(scenario); err == nil {
+			err = scenario.Print(os.Stdout, scenarioFormat(*_scenario))
+		}
 		if err != nil {
-			fmt.Printf("ERROR: Can not load source file %s\n", *_source)
-			return
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
 		}
-	} else {
-		sourceData = &Source{}
+		return 0
 	}
 
+	*_method = strings.ToUpper(*_method)
+
 	URL, err := url.Parse(*_url)
 	if err != nil {
 		fmt.Printf("ERROR: URL is broken %s\n", *_url)
-		return
+		return 1
+	}
+
+	//HAR filter of source and groups files
+	filter := &meter.HARFilter{
+		Hosts:        meter.SplitList(*_harHost),
+		Methods:      meter.SplitList(*_harMethod),
+		StripCookies: *_harNoCookies,
+	}
+	if *_harPath != "" {
+		if filter.Path, err = regexp.Compile(*_harPath); err != nil {
+			fmt.Printf("ERROR: HAR path regexp is broken %s\n", *_harPath)
+			return 1
+		}
+	}
+
+	groups, err := loadGroups(scenario, URL, filter)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	if scenario != nil && len(scenario.Requests) > 0 && *_source == "" {
+		sourceData, err = meter.NewRequestsSource(scenario.Requests, URL)
+		if err != nil {
+			fmt.Printf("ERROR: Scenario file %s %v\n", *_scenario, err)
+			return 1
+		}
+	} else if len(groups) > 0 && *_source == "" {
+		sourceData = &meter.Source{}
+	} else if sourceData, err = loadSource(*_source, URL, filter); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	header, err := requestHeaders(*_headersFile, _headers)
+	if err != nil {
+		fmt.Printf("ERROR: Can not load headers file %s %v\n", *_headersFile, err)
+		return 1
 	}
 
 	if *_cpuprofile != "" {
 		f, err := os.Create(*_cpuprofile)
 		if err != nil {
 			fmt.Printf("Can not start cpu proffile %v\n", err)
-			return
+			return 1
 		}
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
 
-	config := &Config{
-		Method:         *_method,
-		Host:           URL.Host,
-		Url:            URL,
-		Connections:    *_connection,
-		Threads:        *_threads,
-		MRQ:            *_mrq,
-		Verbose:        *_verbose,
-		ExcludeSeconds: *_excludeSeconds,
-		Source:         sourceData,
-		Duration:       *_duration,
-		WorkerQuit:     make(chan bool, *_threads),
-		WorkerQuited:   make(chan bool, *_threads),
-		StatsQuit:      make(chan bool, 1),
-		StatsQuited:    make(chan bool, 1),
-		RequestStats:   make(chan *RequestStats, *_connection*512),
+	config := &meter.Config{
+		Method:           *_method,
+		Header:           header,
+		Url:              URL,
+		RawURL:           *_url,
+		Template:         *_template,
+		Connections:      *_connection,
+		Threads:          *_threads,
+		MRQ:              *_mrq,
+		Rate:             *_rate,
+		Requests:         *_requests,
+		PerConnection:    *_perConnection,
+		Reconnect:        *_reconnect,
+		ConnectTimeout:   *_connectTimeout,
+		WriteTimeout:     *_writeTimeout,
+		FirstByteTimeout: *_ttfbTimeout,
+		ResponseTimeout:  *_timeout,
+		DrainTimeout:     *_drainTimeout,
+		Verbose:          *_verbose,
+		Precision:        *_precision,
+		ExcludeSeconds:   *_excludeSeconds,
+		Source:           sourceData,
+		Groups:           groups,
+		Duration:         *_duration,
+		Thresholds:       _thresholds,
+		Agents:           meter.SplitList(*_agents),
+		AgentToken:       *_agentToken,
+		TLS: &meter.TLSOptions{
+			ServerName: *_tlsServerName,
+			Insecure:   *_tlsInsecure,
+			CAFile:     *_tlsCA,
+			CertFile:   *_tlsCert,
+			KeyFile:    *_tlsKey,
+			MinVersion: *_tlsMin,
+			MaxVersion: *_tlsMax,
+			Ciphers:    *_tlsCiphers,
+		},
+	}
+	if *_agentCA != "" {
+		config.AgentTLS = &meter.TLSOptions{CAFile: *_agentCA}
+	}
+	//Zero drain timeout is default of library
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = -1
+	}
+
+	//Request budget without -d is not limited by time
+	if config.Requests > 0 || config.PerConnection > 0 {
+		durationSet := false
+		flag.Visit(func(f *flag.Flag) {
+			durationSet = durationSet || f.Name == "d"
+		})
+		if !durationSet {
+			config.Duration = 0
+		}
+	}
+
+	if *_stages != "" {
+		if config.Stages, err = meter.ParseStages(*_stages); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		config.Duration = config.Stages.Duration()
 	}
 
-	if strings.Index(config.Host, ":") > -1 {
-		h := strings.SplitN(config.Host, ":", 2)
-		config.Host = h[0]
+	var search *meter.SearchOptions
+	if *_search != "" {
+		search = &meter.SearchOptions{
+			Mode:   *_search,
+			Start:  *_searchStart,
+			Step:   *_searchStep,
+			Max:    *_searchMax,
+			Window: *_searchWindow,
+			SLO:    _searchSLO,
+		}
+		if err = search.Validate(); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+
+	//Report format
+	output := *_output
+	if output == "" {
+		output = "text"
+		if strings.HasSuffix(*_outFile, ".json") {
+			output = "json"
+		}
+	}
+	if output != "text" && output != "json" {
+		fmt.Printf("ERROR: Unknown report format %s\n", output)
+		return 1
+	}
+	//Keep stdout clean for JSON report
+	config.Log = os.Stdout
+	if output == "json" && *_outFile == "" {
+		config.Log = os.Stderr
+	}
+
+	if *_template && *_vars != "" {
+		if config.Vars, err = meter.LoadTemplateVars(*_vars); err != nil {
+			fmt.Printf("ERROR: Can not load vars file %s %v\n", *_vars, err)
+			return 1
+		}
+	}
+
+	if *_csvFile != "" {
+		config.CSV, err = meter.NewCSVWriter(*_csvFile)
+		if err != nil {
+			fmt.Printf("ERROR: Can not create CSV file %s %v\n", *_csvFile, err)
+			return 1
+		}
+		defer config.CSV.Close()
+	}
+
+	if *_metricsAddr != "" {
+		config.Metrics = meter.NewMetrics()
+		if err = meter.StartMetricsServer(*_metricsAddr, config.Metrics); err != nil {
+			fmt.Printf("ERROR: Can not start metrics server %v\n", err)
+			return 1
+		}
 	}
 
 	runtime.GOMAXPROCS(*_threads)
@@ -124,64 +322,225 @@ func main() {
 		logUrl = config.Url.Host
 	}
 
-	if config.MRQ == -1 {
-		fmt.Printf("Running test threads: %d, connections: %d in %v %s %s\n", *_threads, config.Connections, config.Duration, config.Method, logUrl)
+	duration := fmt.Sprint(config.Duration)
+	if config.Duration == 0 {
+		duration = "unlimited time"
+	}
+	if search != nil {
+		fmt.Fprintf(config.Log, "Running capacity search threads: %d, connections: %d, mode: %s, window: %v %s %s\n", *_threads, config.Connections, search.Mode, search.Window, config.Method, logUrl)
+	} else if len(config.Stages) > 0 {
+		fmt.Fprintf(config.Log, "Running test threads: %d, connections: %d, stages: %s, in %v %s %s\n", *_threads, config.Stages.MaxConnections(config.Connections), *_stages, duration, config.Method, logUrl)
+	} else if config.Rate > 0 {
+		fmt.Fprintf(config.Log, "Running test threads: %d, connections: %d, rate: %d req/sec, in %v %s %s\n", *_threads, config.Connections, config.Rate, duration, config.Method, logUrl)
+	} else if config.MRQ == -1 {
+		fmt.Fprintf(config.Log, "Running test threads: %d, connections: %d in %v %s %s\n", *_threads, config.Connections, duration, config.Method, logUrl)
 	} else {
-		fmt.Printf("Running test threads: %d, connections: %d, max req/sec: %d, in %v %s %s\n", *_threads, config.Connections, config.MRQ, config.Duration, config.Method, logUrl)
+		fmt.Fprintf(config.Log, "Running test threads: %d, connections: %d, max req/sec: %d, in %v %s %s\n", *_threads, config.Connections, config.MRQ, duration, config.Method, logUrl)
+	}
+	if config.Requests > 0 {
+		fmt.Fprintf(config.Log, "Stop after %d requests\n", config.Requests)
+	}
+	if config.PerConnection > 0 {
+		fmt.Fprintf(config.Log, "Stop after %d requests per connection\n", config.PerConnection)
+	}
+	if len(config.Agents) > 0 {
+		fmt.Fprintf(config.Log, "Agents: %s, threads and connections are per agent\n", strings.Join(config.Agents, ", "))
+	}
+
+	//SIGTERM stops test, second signal exits without waiting in flight responses
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signalChan
+		cancel()
+		<-signalChan
+		fmt.Println("ERROR: Interrupted")
+		os.Exit(1)
+	}()
+
+	if search != nil {
+		return runSearch(ctx, config, search, output)
 	}
 
-	config.ConnectionManager = NewConnectionManager(config)
+	//SIGUSR1 prints stats snapshot, SIGUSR2 resets stats
+	snapshot := make(chan bool, 1)
+	reset := make(chan bool, 1)
+	config.Snapshot = snapshot
+	config.Reset = reset
+	controlChan := make(chan os.Signal, 1)
+	notifyControl(controlChan)
+	go func() {
+		for sig := range controlChan {
+			if len(config.Agents) > 0 {
+				fmt.Fprintf(config.Log, "ERROR: Stats snapshot and reset are not supported with agents\n")
+				continue
+			}
+			action := reset
+			if sig == snapshotSignal {
+				action = snapshot
+			}
+			//Repeated signals are merged while aggregator is busy
+			select {
+			case action <- true:
+			default:
+			}
+		}
+	}()
 
-	//check any connect
-	anyConnected := false
-	for i := 0; i < config.Connections && !anyConnected; i++ {
-		connection := config.ConnectionManager.conns[i]
-		if connection.IsConnected() {
-			anyConnected = true
+	//Stats of completed agents are reported on controller error
+	report, err := meter.Run(ctx, *config)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		if report.Stats == nil {
+			return 1
 		}
 	}
-	if !anyConnected {
-		fmt.Printf("Can not connect to %s\n", config.Url.Host)
-		return
+	//Print result
+	if *_outFile == "" && output == "json" {
+		meter.PrintJSONStats(os.Stdout, &report)
+	} else {
+		meter.PrintStats(os.Stdout, &report)
 	}
+	passed := meter.PrintThresholds(config.Log, report.Thresholds)
+	if *_outFile != "" {
+		if err := writeReport(*_outFile, output, &report); err != nil {
+			fmt.Printf("ERROR: Can not write report %s %v\n", *_outFile, err)
+			return 1
+		}
+	}
+	if err != nil {
+		return 1
+	}
+	if !passed {
+		return 2
+	}
+	return 0
+}
 
-	go StartStatsAggregator(config)
-
-	for i := 0; i < config.Threads; i++ {
-		go NewThread(config)
+//Load source file by format, empty source is URL only
+func loadSource(fileName string, URL *url.URL, filter *meter.HARFilter) (*meter.Source, error) {
+	if meter.IsHARSource(fileName) {
+		source, err := meter.LoadHARSource(fileName, filter)
+		if err != nil {
+			return nil, fmt.Errorf("Can not load source file %s %v", fileName, err)
+		}
+		return source, nil
+	}
+	if meter.IsJSONSource(fileName) {
+		source, err := meter.LoadJSONSource(fileName, URL)
+		if err != nil {
+			return nil, fmt.Errorf("Can not load source file %s %v", fileName, err)
+		}
+		return source, nil
+	}
+	if *_method == "POST" || *_method == "PUT" || (len(fileName) > 0 && FileExists(fileName)) {
+		source, err := meter.LoadSource(fileName, "\n")
+		if err != nil {
+			return nil, fmt.Errorf("Can not load source file %s", fileName)
+		}
+		return source, nil
 	}
+	return &meter.Source{}, nil
+}
 
-	//Start SIGTERM listen
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+//Request groups of -group flags, scenario file groups are used without flags
+func loadGroups(scenario *Scenario, URL *url.URL, filter *meter.HARFilter) ([]*meter.RequestGroup, error) {
+	var result []*meter.RequestGroup
+	if len(_groups) > 0 {
+		for _, spec := range _groups {
+			source, err := loadSource(spec.Source, URL, filter)
+			if err != nil {
+				return nil, fmt.Errorf("Group %s %v", spec.Name, err)
+			}
+			result = append(result, &meter.RequestGroup{Name: spec.Name, Weight: spec.Weight, Source: source})
+		}
+		return result, nil
+	}
+	if scenario == nil {
+		return nil, nil
+	}
+	for _, group := range scenario.Groups {
+		var (
+			source *meter.Source
+			err    error
+		)
+		if len(group.Requests) > 0 {
+			source, err = meter.NewRequestsSource(group.Requests, URL)
+		} else {
+			source, err = loadSource(group.Source, URL, filter)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Group %s %v", group.Name, err)
+		}
+		result = append(result, &meter.RequestGroup{Name: group.Name, Weight: group.Weight, Source: source})
+	}
+	return result, nil
+}
 
-	//Wait timers or SIGTERM
-	select {
-	case <-time.After(config.Duration):
-	case <-signalChan:
+//Headers of headers file, values are overridden by -H headers
+func requestHeaders(fileName string, flags map[string][]string) (map[string][]string, error) {
+	result := map[string][]string{}
+	if fileName != "" {
+		if err := meter.LoadHeaders(fileName, result); err != nil {
+			return nil, err
+		}
 	}
-	for i := 0; i < config.Threads; i++ {
-		config.WorkerQuit <- true
+	for key, values := range flags {
+		result[key] = values
 	}
-	//Wait for threads complete
-	for i := 0; i < config.Threads; i++ {
-		<-config.WorkerQuited
+	return result, nil
+}
+
+//Write report to file in text or json format
+func writeReport(fileName string, output string, report *meter.Report) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if output == "json" {
+		return meter.PrintJSONStats(f, report)
 	}
+	meter.PrintStats(f, report)
+	meter.PrintThresholds(f, report.Thresholds)
+	return nil
+}
 
-	//Stop stats aggregator
-	config.StatsQuit <- true
-	//Close connections
-	for i := 0; i < config.Connections; i++ {
-		connection := config.ConnectionManager.conns[i]
-		if !connection.IsConnected() {
-			continue
+//Run capacity search and print result, returns process exit code
+func runSearch(ctx context.Context, config *meter.Config, search *meter.SearchOptions, output string) int {
+	result, err := meter.RunSearch(ctx, *config, search)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+	if *_outFile == "" && output == "json" {
+		meter.PrintJSONSearch(os.Stdout, result)
+	} else {
+		meter.PrintSearch(os.Stdout, result)
+	}
+	if *_outFile != "" {
+		if err := writeSearchReport(*_outFile, output, result); err != nil {
+			fmt.Printf("ERROR: Can not write report %s %v\n", *_outFile, err)
+			return 1
 		}
-		connection.conn.Close()
 	}
-	//Wait stats aggregator complete
-	<-config.StatsQuited
-	//Print result
-	PrintStats(os.Stdout, config)
+	return 0
+}
+
+//Write capacity search result to file in text or json format
+func writeSearchReport(fileName string, output string, result *meter.SearchResult) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if output == "json" {
+		return meter.PrintJSONSearch(f, result)
+	}
+	meter.PrintSearch(f, result)
+	return nil
 }
 
 func FileExists(name string) bool {
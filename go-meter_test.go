@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRequestHeaders(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "headers.txt")
+	if err := ioutil.WriteFile(fileName, []byte("# defaults\nAccept: text/html\nX-Tag: a\nX-Tag: b\n\nX-File: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		fileName string
+		flags    map[string][]string
+		result   map[string][]string
+	}{
+		{"file only", fileName, nil, map[string][]string{"Accept": {"text/html"}, "X-Tag": {"a", "b"}, "X-File": {"1"}}},
+		{"flags only", "", map[string][]string{"Accept": {"*/*"}}, map[string][]string{"Accept": {"*/*"}}},
+		//-H replaces all values of file header
+		{"flags override file", fileName, map[string][]string{"Accept": {"*/*"}, "X-Tag": {"c"}},
+			map[string][]string{"Accept": {"*/*"}, "X-Tag": {"c"}, "X-File": {"1"}}},
+	}
+	for _, test := range tests {
+		result, err := requestHeaders(test.fileName, test.flags)
+		if err != nil || !reflect.DeepEqual(result, test.result) {
+			t.Errorf("%s: %v, %v", test.name, result, err)
+		}
+	}
+	if _, err := requestHeaders("not-exists.txt", nil); err == nil {
+		t.Error("missing file: expected error")
+	}
+}
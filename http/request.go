@@ -12,6 +12,8 @@ type Request struct {
 	Method string
 
 	URL *url.URL
+	//Raw request URI, URL.RequestURI() is used if empty
+	RequestURI string
 
 	Header map[string][]string
 
@@ -25,8 +27,9 @@ type Request struct {
 }
 
 func (req *Request) Write(w io.Writer) error {
+	hasBody := req.Method == "POST" || req.Method == "PUT" || len(req.Body) > 0
 	headers := "Host: " + req.Host + "\r\n"
-	if req.Method == "POST" || req.Method == "PUT" {
+	if hasBody {
 		headers += fmt.Sprintf("Content-Length: %d\r\n", req.ContentLength)
 	}
 	if req.Header != nil {
@@ -39,9 +42,13 @@ func (req *Request) Write(w io.Writer) error {
 			}
 		}
 	}
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
 	pocket := fmt.Sprintf("%s %s HTTP/1.1\r\n%s\r\n",
 		valueOrDefault(req.Method, "GET"),
-		req.URL.RequestURI(),
+		uri,
 		headers,
 	)
 
@@ -49,7 +56,7 @@ func (req *Request) Write(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	if req.Method == "POST" || req.Method == "PUT" {
+	if hasBody {
 		req.BufferSize = req.ContentLength
 		_, err = w.Write(req.Body)
 		if err != nil {
@@ -0,0 +1,31 @@
+package http
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequestWriteHost(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1:8080/items?id=1")
+	req := &Request{
+		Method: "GET",
+		URL:    u,
+		Header: map[string][]string{"Host": {"other.test"}, "Accept": {"*/*"}},
+		Host:   "service.test",
+	}
+	buf := &bytes.Buffer{}
+	if err := req.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.String()
+	//Host is written once from request host, header value is not repeated
+	if !strings.HasPrefix(raw, "GET /items?id=1 HTTP/1.1\r\nHost: service.test\r\n") || strings.Count(raw, "Host:") != 1 ||
+		!strings.Contains(raw, "\r\nAccept: */*\r\n") || !strings.HasSuffix(raw, "\r\n\r\n") {
+		t.Errorf("request:\n%s", raw)
+	}
+	if req.BufferSize != int64(len(raw)) {
+		t.Errorf("buffer size %d, written %d", req.BufferSize, len(raw))
+	}
+}
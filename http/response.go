@@ -3,6 +3,8 @@ package http
 import (
 	"bufio"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/textproto"
 	"strconv"
 	"strings"
@@ -19,21 +21,29 @@ type Response struct {
 	ContentLength int64
 
 	BufferSize int64
+
+	//Read timestamps
+	FirstByte   time.Time
+	HeadersDone time.Time
+	BodyDone    time.Time
 }
 
-func ReadResponse(r *bufio.Reader, tr *textproto.Reader) (time.Time, *Response, error) {
+func ReadResponse(r *bufio.Reader, tr *textproto.Reader) (*Response, error) {
 	resp := &Response{}
 
+	if _, err := r.Peek(1); err != nil {
+		return nil, err
+	}
+	resp.FirstByte = time.Now()
 	line, err := tr.ReadLine()
-	t := time.Now()
 	if err != nil {
-		return t, nil, err
+		return nil, err
 	}
 	resp.BufferSize += int64(len(line) + 2)
 	f := strings.SplitN(line, " ", 3)
 
 	if len(f) < 2 {
-		return t, nil, errors.New("Response Header ERROR")
+		return nil, errors.New("Response Header ERROR")
 	}
 
 	reasonPhrase := ""
@@ -43,7 +53,7 @@ func ReadResponse(r *bufio.Reader, tr *textproto.Reader) (time.Time, *Response,
 	resp.Status = f[1] + " " + reasonPhrase
 	resp.StatusCode, err = strconv.Atoi(f[1])
 	if err != nil {
-		return t, nil, errors.New("malformed HTTP status code")
+		return nil, errors.New("malformed HTTP status code")
 	}
 
 	resp.Header = make(map[string][]string)
@@ -51,16 +61,24 @@ func ReadResponse(r *bufio.Reader, tr *textproto.Reader) (time.Time, *Response,
 		line, err := tr.ReadLine()
 		resp.BufferSize += int64(len(line) + 2)
 		if err != nil {
-			return t, nil, errors.New("Response Header ERROR")
+			return nil, errors.New("Response Header ERROR")
 		}
 		if len(line) == 0 {
 			break
 		} else {
-			f := strings.SplitN(line, ":", 2)
-			if len(f) == 2 {
-				resp.Header[f[0]] = append(resp.Header[strings.TrimSpace(f[0])], strings.TrimSpace(f[1]))
-			}
+			resp.addHeaderLine(line)
+		}
+	}
+
+	resp.HeadersDone = time.Now()
+
+	if isChunked(resp.Header["Transfer-Encoding"]) {
+		err = readChunked(r, tr, resp)
+		if err != nil {
+			return nil, err
 		}
+		resp.BodyDone = time.Now()
+		return resp, nil
 	}
 
 	if cl := resp.Header["Content-Length"]; len(cl) > 0 {
@@ -70,20 +88,79 @@ func ReadResponse(r *bufio.Reader, tr *textproto.Reader) (time.Time, *Response,
 		}
 	}
 	if resp.ContentLength > 0 {
-
-		read := 0
-		for {
-			p := make([]byte, resp.ContentLength-int64(read))
-			n, err := r.Read(p)
-			if err != nil {
-				return t, nil, err
-			}
-			read += n
-			if int64(read) == resp.ContentLength {
-				break
-			}
+		_, err = io.CopyN(ioutil.Discard, r, resp.ContentLength)
+		if err != nil {
+			return nil, err
 		}
 	}
 	resp.BufferSize += int64(resp.ContentLength)
-	return t, resp, nil
+	resp.BodyDone = time.Now()
+	return resp, nil
+}
+
+//Add "Name: value" header line to response headers
+func (resp *Response) addHeaderLine(line string) {
+	f := strings.SplitN(line, ":", 2)
+	if len(f) == 2 {
+		key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(f[0]))
+		resp.Header[key] = append(resp.Header[key], strings.TrimSpace(f[1]))
+	}
+}
+
+//Check last transfer coding is chunked
+func isChunked(te []string) bool {
+	if len(te) == 0 {
+		return false
+	}
+	codings := strings.Split(te[len(te)-1], ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+//Read chunked body with extensions and trailers, ContentLength is decoded body size
+func readChunked(r *bufio.Reader, tr *textproto.Reader, resp *Response) error {
+	resp.ContentLength = 0
+	for {
+		line, err := tr.ReadLine()
+		if err != nil {
+			return err
+		}
+		resp.BufferSize += int64(len(line) + 2)
+		//Skip chunk extensions
+		if i := strings.Index(line, ";"); i > -1 {
+			line = line[:i]
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil || size < 0 {
+			return errors.New("malformed chunk size")
+		}
+		if size == 0 {
+			break
+		}
+		if _, err = io.CopyN(ioutil.Discard, r, size); err != nil {
+			return err
+		}
+		resp.ContentLength += size
+		resp.BufferSize += size
+		//Chunk data CRLF
+		line, err = tr.ReadLine()
+		if err != nil {
+			return err
+		}
+		if len(line) != 0 {
+			return errors.New("malformed chunk delimiter")
+		}
+		resp.BufferSize += 2
+	}
+	//Trailers
+	for {
+		line, err := tr.ReadLine()
+		if err != nil {
+			return err
+		}
+		resp.BufferSize += int64(len(line) + 2)
+		if len(line) == 0 {
+			return nil
+		}
+		resp.addHeaderLine(line)
+	}
 }
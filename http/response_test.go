@@ -0,0 +1,166 @@
+package http
+
+import (
+	"bufio"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func readResponse(raw string) (*Response, *bufio.Reader, error) {
+	r := bufio.NewReader(strings.NewReader(raw))
+	resp, err := ReadResponse(r, textproto.NewReader(r))
+	return resp, r, err
+}
+
+func TestReadResponseChunked(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		length   int64
+		trailers map[string]string
+	}{
+		{
+			name:   "plain chunks",
+			raw:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+			length: 11,
+		},
+		{
+			name:   "upper case size",
+			raw:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nA\r\n0123456789\r\n0\r\n\r\n",
+			length: 10,
+		},
+		{
+			name:   "chunk extensions",
+			raw:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;name=value\r\nhello\r\n3 ; x\r\nabc\r\n0;last\r\n\r\n",
+			length: 8,
+		},
+		{
+			name:     "trailers",
+			raw:      "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\nx-checksum: abc\r\nExpires: never\r\n\r\n",
+			length:   2,
+			trailers: map[string]string{"X-Checksum": "abc", "Expires": "never"},
+		},
+		{
+			name:   "last coding is chunked",
+			raw:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, Chunked\r\n\r\n1\r\nz\r\n0\r\n\r\n",
+			length: 1,
+		},
+		{
+			name:   "empty body",
+			raw:    "HTTP/1.1 204 No Content\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			length: 0,
+		},
+	}
+	for _, test := range tests {
+		resp, r, err := readResponse(test.raw + "HTTP/1.1 200 OK\r\n")
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if resp.ContentLength != test.length {
+			t.Errorf("%s: ContentLength = %d, want %d", test.name, resp.ContentLength, test.length)
+		}
+		if resp.BufferSize != int64(len(test.raw)) {
+			t.Errorf("%s: BufferSize = %d, want %d", test.name, resp.BufferSize, len(test.raw))
+		}
+		for key, value := range test.trailers {
+			if got := resp.Header[key]; len(got) != 1 || got[0] != value {
+				t.Errorf("%s: trailer %s = %v, want %s", test.name, key, got, value)
+			}
+		}
+		//Next pipelined response must be untouched
+		if line, _ := r.ReadString('\n'); line != "HTTP/1.1 200 OK\r\n" {
+			t.Errorf("%s: next response line = %q", test.name, line)
+		}
+	}
+}
+
+func TestReadResponseChunkedErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not hex size", "zz\r\nhello\r\n0\r\n\r\n"},
+		{"empty size", "\r\nhello\r\n0\r\n\r\n"},
+		{"negative size", "-5\r\nhello\r\n0\r\n\r\n"},
+		{"overflowing size", "1ffffffffffffffff\r\nhello\r\n0\r\n\r\n"},
+		{"huge size", "7fffffffffffffff\r\nhello\r\n0\r\n\r\n"},
+		{"size larger than data", "a\r\nhello\r\n0\r\n\r\n"},
+		{"missing delimiter", "5\r\nhelloX\r\n0\r\n\r\n"},
+		{"truncated data", "5\r\nhel"},
+		{"truncated before last chunk", "5\r\nhello\r\n"},
+		{"truncated trailers", "5\r\nhello\r\n0\r\nX-Trailer: 1\r\n"},
+		{"no body", ""},
+	}
+	for _, test := range tests {
+		raw := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + test.body
+		if _, _, err := readResponse(raw); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestReadResponseContentLength(t *testing.T) {
+	raw := "HTTP/1.1 404 Not Found\r\nContent-Length: 5\r\nX-Multi: a\r\nx-multi: b\r\n\r\nhello"
+	resp, _, err := readResponse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 || resp.Status != "404 Not Found" {
+		t.Errorf("status = %d %q", resp.StatusCode, resp.Status)
+	}
+	if resp.ContentLength != 5 || resp.BufferSize != int64(len(raw)) {
+		t.Errorf("ContentLength = %d, BufferSize = %d", resp.ContentLength, resp.BufferSize)
+	}
+	if got := resp.Header["X-Multi"]; len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("X-Multi = %v", got)
+	}
+	if _, _, err = readResponse("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello"); err == nil {
+		t.Error("truncated body: expected error")
+	}
+	if _, _, err = readResponse("HTTP/1.1 2xx OK\r\n\r\n"); err == nil {
+		t.Error("malformed status: expected error")
+	}
+}
+
+func TestIsChunked(t *testing.T) {
+	tests := []struct {
+		te     []string
+		result bool
+	}{
+		{nil, false},
+		{[]string{"chunked"}, true},
+		{[]string{"CHUNKED"}, true},
+		{[]string{"gzip, chunked"}, true},
+		{[]string{"chunked, gzip"}, false},
+		{[]string{"chunked", "gzip"}, false},
+		{[]string{"gzip", " chunked "}, true},
+		{[]string{"identity"}, false},
+	}
+	for _, test := range tests {
+		if got := isChunked(test.te); got != test.result {
+			t.Errorf("isChunked(%q) = %v, want %v", test.te, got, test.result)
+		}
+	}
+}
+
+func TestAddHeaderLine(t *testing.T) {
+	resp := &Response{Header: map[string][]string{}}
+	resp.addHeaderLine("content-type:  text/plain ")
+	resp.addHeaderLine("X-Empty:")
+	resp.addHeaderLine("Location: http://host:8080/path")
+	resp.addHeaderLine("no colon line")
+	if got := resp.Header["Content-Type"]; len(got) != 1 || got[0] != "text/plain" {
+		t.Errorf("Content-Type = %v", got)
+	}
+	if got := resp.Header["X-Empty"]; len(got) != 1 || got[0] != "" {
+		t.Errorf("X-Empty = %v", got)
+	}
+	if got := resp.Header["Location"]; len(got) != 1 || got[0] != "http://host:8080/path" {
+		t.Errorf("Location = %v", got)
+	}
+	if len(resp.Header) != 3 {
+		t.Errorf("headers = %v", resp.Header)
+	}
+}
@@ -0,0 +1,378 @@
+package meter
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+//Version of controller/agent messages, agent rejects other versions
+const agentProtocolVersion = 4
+
+//Delay of agents start after all agents are ready, agents start at the same time of their clocks
+const agentStartDelay = time.Second
+
+//Max wait of agent start time, longer wait is clocks difference of controller and agent
+const agentMaxStartWait = time.Minute
+
+//Max wait of TLS handshake and config message of connected controller
+var agentConfigTimeout = time.Duration(10) * time.Second
+
+//Controller/agent message types
+const (
+	agentConfigMessage = "config"
+	agentReadyMessage  = "ready"
+	agentStartMessage  = "start"
+	agentStopMessage   = "stop"
+	agentStatsMessage  = "stats"
+	agentDoneMessage   = "done"
+	agentErrorMessage  = "error"
+)
+
+//JSON line message between controller and agent
+type agentMessage struct {
+	Type    string       `json:"type"`
+	Version int          `json:"version,omitempty"`
+	Token   string       `json:"token,omitempty"`
+	Config  *AgentConfig `json:"config,omitempty"`
+	//Test start time of all agents, Unix nanoseconds
+	Start int64 `json:"start,omitempty"`
+	//Per second stats
+	Second time.Duration         `json:"second,omitempty"`
+	Stats  *StatsSourcePerSecond `json:"stats,omitempty"`
+	//Final stats
+	Result *StatsSource `json:"result,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+//Test settings pushed by controller, rates are already split between agents.
+//TLS has PEM contents of controller files, agent does not read local files
+type AgentConfig struct {
+	Method           string              `json:"method"`
+	URL              string              `json:"url"`
+	Header           map[string][]string `json:"header"`
+	TLS              *TLSOptions         `json:"tls"`
+	Connections      int                 `json:"connections"`
+	Threads          int                 `json:"threads"`
+	MRQ              int                 `json:"mrq"`
+	Rate             int                 `json:"rate"`
+	RequestsLimit    int                 `json:"requests_limit"`
+	PerConnection    int                 `json:"per_connection"`
+	Stages           Stages              `json:"stages"`
+	Reconnect        bool                `json:"reconnect"`
+	ConnectTimeout   time.Duration       `json:"connect_timeout"`
+	WriteTimeout     time.Duration       `json:"write_timeout"`
+	FirstByteTimeout time.Duration       `json:"first_byte_timeout"`
+	ResponseTimeout  time.Duration       `json:"response_timeout"`
+	DrainTimeout     time.Duration       `json:"drain_timeout"`
+	Precision        int                 `json:"precision"`
+	ExcludeSeconds   time.Duration       `json:"exclude_seconds"`
+	Duration         time.Duration       `json:"duration"`
+	Data             [][]byte            `json:"data,omitempty"`
+	Requests         []agentRequest      `json:"requests,omitempty"`
+	Template         bool                `json:"template"`
+	Vars             [][]string          `json:"vars,omitempty"`
+	Groups           []agentGroup        `json:"groups,omitempty"`
+}
+
+type agentGroup struct {
+	Name     string         `json:"name"`
+	Weight   int            `json:"weight"`
+	Data     [][]byte       `json:"data,omitempty"`
+	Requests []agentRequest `json:"requests,omitempty"`
+}
+
+type agentRequest struct {
+	Method string              `json:"method"`
+	URL    string              `json:"url"`
+	RawURI string              `json:"raw_uri"`
+	Header map[string][]string `json:"header"`
+	Body   []byte              `json:"body"`
+}
+
+//Create agent settings from controller config, rate of agent index is part of total rate
+func NewAgentConfig(config *Config, index int) (*AgentConfig, error) {
+	tlsOptions, err := config.TLS.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+	count := len(config.Agents)
+	result := &AgentConfig{
+		Method:           config.Method,
+		URL:              config.RawURL,
+		Header:           config.Header,
+		TLS:              tlsOptions,
+		Connections:      config.Connections,
+		Threads:          config.Threads,
+		MRQ:              config.MRQ,
+		Rate:             splitRate(config.Rate, count, index),
+		RequestsLimit:    splitRate(config.Requests, count, index),
+		PerConnection:    config.PerConnection,
+		Reconnect:        config.Reconnect,
+		ConnectTimeout:   config.ConnectTimeout,
+		WriteTimeout:     config.WriteTimeout,
+		FirstByteTimeout: config.FirstByteTimeout,
+		ResponseTimeout:  config.ResponseTimeout,
+		DrainTimeout:     config.DrainTimeout,
+		Precision:        config.Precision,
+		ExcludeSeconds:   config.ExcludeSeconds,
+		Duration:         config.Duration,
+		Template:         config.Template,
+		Vars:             config.Vars.Records(),
+	}
+	if config.MRQ > 0 {
+		result.MRQ = splitRate(config.MRQ, count, index)
+		if result.MRQ == 0 {
+			result.MRQ = 1
+		}
+	}
+	for _, stage := range config.Stages {
+		result.Stages = append(result.Stages, Stage{Duration: stage.Duration, Rate: splitRate(stage.Rate, count, index), Connections: stage.Connections})
+	}
+	if config.Source != nil {
+		result.Data = config.Source.Data
+		result.Requests = newAgentRequests(config.Source)
+	}
+	for _, group := range config.Groups {
+		result.Groups = append(result.Groups, agentGroup{
+			Name:     group.Name,
+			Weight:   group.Weight,
+			Data:     group.Source.Data,
+			Requests: newAgentRequests(group.Source),
+		})
+	}
+	return result, nil
+}
+
+func newAgentRequests(source *Source) []agentRequest {
+	var result []agentRequest
+	for _, request := range source.Requests {
+		result = append(result, agentRequest{
+			Method: request.Method,
+			URL:    request.URL.String(),
+			RawURI: request.RawURI,
+			Header: request.Header,
+			Body:   request.Body,
+		})
+	}
+	return result
+}
+
+//Source of agent data and requests
+func newAgentSource(data [][]byte, requests []agentRequest) (*Source, error) {
+	result := &Source{Data: data}
+	for _, request := range requests {
+		u, err := url.Parse(request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("URL is broken %s", request.URL)
+		}
+		result.Requests = append(result.Requests, &SourceRequest{
+			Method: request.Method,
+			URL:    u,
+			Header: request.Header,
+			Body:   request.Body,
+			RawURI: request.RawURI,
+		})
+	}
+	return result, nil
+}
+
+//Part of total rate for agent index, remainder is given to first agents
+func splitRate(rate int, count int, index int) int {
+	result := rate / count
+	if index < rate%count {
+		result++
+	}
+	return result
+}
+
+//Create agent test config
+func (this *AgentConfig) Config(log io.Writer) (*Config, error) {
+	URL, err := url.Parse(this.URL)
+	if err != nil {
+		return nil, fmt.Errorf("URL is broken %s", this.URL)
+	}
+	config := &Config{
+		Method:           this.Method,
+		Header:           this.Header,
+		Url:              URL,
+		RawURL:           this.URL,
+		Template:         this.Template,
+		Connections:      this.Connections,
+		Threads:          this.Threads,
+		MRQ:              this.MRQ,
+		Rate:             this.Rate,
+		Requests:         this.RequestsLimit,
+		PerConnection:    this.PerConnection,
+		Stages:           this.Stages,
+		Reconnect:        this.Reconnect,
+		ConnectTimeout:   this.ConnectTimeout,
+		WriteTimeout:     this.WriteTimeout,
+		FirstByteTimeout: this.FirstByteTimeout,
+		ResponseTimeout:  this.ResponseTimeout,
+		DrainTimeout:     this.DrainTimeout,
+		Precision:        this.Precision,
+		ExcludeSeconds:   this.ExcludeSeconds,
+		Duration:         this.Duration,
+		Log:              log,
+	}
+	if this.TLS != nil {
+		tlsOptions := *this.TLS
+		tlsOptions.CAFile, tlsOptions.CertFile, tlsOptions.KeyFile = "", "", ""
+		config.TLS = &tlsOptions
+	}
+	if config.Source, err = newAgentSource(this.Data, this.Requests); err != nil {
+		return nil, err
+	}
+	for _, group := range this.Groups {
+		source, err := newAgentSource(group.Data, group.Requests)
+		if err != nil {
+			return nil, err
+		}
+		config.Groups = append(config.Groups, &RequestGroup{Name: group.Name, Weight: group.Weight, Source: source})
+	}
+	if this.Template && len(this.Vars) > 0 {
+		if config.Vars, err = NewTemplateVars(this.Vars); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+//Listen controller connections, tests are run one by one.
+//Address without host like ":7070" is loopback, other addresses require token and TLS certificate,
+//controller sends target credentials to agent. TLS is nil for plain loopback agent
+func RunAgent(addr string, token string, tlsOptions *TLSOptions, log io.Writer) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	var tlsConfig *tls.Config
+	if tlsOptions != nil {
+		if tlsConfig, err = NewTLSConfig(tlsOptions, ""); err != nil {
+			return err
+		}
+		if len(tlsConfig.Certificates) == 0 {
+			return errors.New("agent TLS certificate and key are required")
+		}
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	if ip, ok := listener.Addr().(*net.TCPAddr); ok && !ip.IP.IsLoopback() && (token == "" || tlsConfig == nil) {
+		return fmt.Errorf("agent token and TLS certificate are required to listen on %s", listener.Addr())
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	fmt.Fprintf(log, "Agent listening on %s\n", listener.Addr())
+	return serveAgents(listener, token, log)
+}
+
+//Accept controller connections until listener is closed
+func serveAgents(listener net.Listener, token string, log io.Writer) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		if err = serveAgent(conn, token, log); err != nil {
+			fmt.Fprintf(log, "ERROR: Controller %s %v\n", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+	}
+}
+
+//Run one controller test
+func serveAgent(conn net.Conn, token string, log io.Writer) error {
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	fail := func(err error) error {
+		encoder.Encode(&agentMessage{Type: agentErrorMessage, Error: err.Error()})
+		return err
+	}
+
+	//Silent controller does not block agent
+	conn.SetReadDeadline(time.Now().Add(agentConfigTimeout))
+	message := agentMessage{}
+	if err := decoder.Decode(&message); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if subtle.ConstantTimeCompare([]byte(message.Token), []byte(token)) != 1 {
+		return fail(errors.New("agent token is wrong"))
+	}
+	if message.Type != agentConfigMessage || message.Config == nil {
+		return fail(fmt.Errorf("unexpected message %s", message.Type))
+	}
+	if message.Version != agentProtocolVersion {
+		return fail(fmt.Errorf("protocol version %d is not supported, agent version is %d", message.Version, agentProtocolVersion))
+	}
+	config, err := message.Config.Config(log)
+	var run *testRun
+	if err == nil {
+		run, err = config.prepare()
+	}
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Fprintf(log, "Test from %s threads: %d, connections: %d, in %v %s %s\n", conn.RemoteAddr(), config.Threads, config.Connections, config.Duration, config.Method, config.Url)
+
+	if err = run.connect(); err != nil {
+		return fail(err)
+	}
+	defer run.manager.Close()
+	if err = encoder.Encode(&agentMessage{Type: agentReadyMessage}); err != nil {
+		return err
+	}
+
+	//Wait start of all agents
+	if err = decoder.Decode(&message); err != nil {
+		return err
+	}
+	if message.Type != agentStartMessage {
+		return fmt.Errorf("test is not started, got %s", message.Type)
+	}
+	wait := time.Unix(0, message.Start).Sub(time.Now())
+	if wait > agentMaxStartWait {
+		return fail(fmt.Errorf("start time is %v later, check clocks of controller and agent", wait))
+	}
+	if wait < 0 {
+		fmt.Fprintf(log, "Start time passed %v ago, check clocks of controller and agent\n", -wait)
+	}
+	time.Sleep(wait)
+	//Stop message or closed controller connection stops test
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		message := agentMessage{}
+		for {
+			err := decoder.Decode(&message)
+			if err != nil || message.Type == agentStopMessage {
+				cancel()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	config.OnSecond = func(second time.Duration, stats *StatsSourcePerSecond) {
+		encoder.Encode(&agentMessage{Type: agentStatsMessage, Second: second, Stats: stats})
+	}
+	runLoad(ctx, run, config.Duration)
+
+	fmt.Fprintf(log, "Test from %s completed, %d requests\n", conn.RemoteAddr(), run.stats.Requests)
+	return encoder.Encode(&agentMessage{Type: agentDoneMessage, Result: run.stats})
+}
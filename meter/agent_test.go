@@ -0,0 +1,222 @@
+package meter
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//Start loopback agent, it is stopped on test cleanup. TLS is nil for plain agent
+func startTestAgent(t *testing.T, token string, tlsConfig *tls.Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	go serveAgents(listener, token, ioutil.Discard)
+	return listener.Addr().String()
+}
+
+func TestRunAgents(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	config := Config{
+		Url:         u,
+		Connections: 2,
+		Threads:     1,
+		Rate:        100,
+		Duration:    time.Second,
+		Agents:      []string{startTestAgent(t, "secret", nil), startTestAgent(t, "secret", nil)},
+		AgentToken:  "secret",
+	}
+	started := time.Now()
+	report, err := Run(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Agents wait shared start time before test
+	if elapsed := time.Now().Sub(started); elapsed < agentStartDelay+config.Duration {
+		t.Errorf("test completed in %v", elapsed)
+	}
+	stats := report.Stats
+	if stats.Requests < 80 || stats.Requests > 120 || stats.Codes[200] != stats.Requests {
+		t.Errorf("requests %d, codes %v", stats.Requests, stats.Codes)
+	}
+	if int(atomic.LoadInt32(&requests)) != stats.Requests {
+		t.Errorf("server got %d requests, stats %d", requests, stats.Requests)
+	}
+	if stats.ConnectionErrors+stats.ReadErrors+stats.WriteErrors+stats.TimeoutErrors != 0 {
+		t.Errorf("errors %+v", stats)
+	}
+}
+
+func TestRunAgentsToken(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1:1/")
+	config := Config{
+		Url:         u,
+		Connections: 1,
+		Threads:     1,
+		Duration:    time.Second,
+		Agents:      []string{startTestAgent(t, "secret", nil)},
+		AgentToken:  "wrong",
+	}
+	report, err := Run(context.Background(), config)
+	if err == nil || !strings.Contains(err.Error(), "token") || report.Stats != nil {
+		t.Errorf("wrong token: %v", err)
+	}
+	for _, token := range []string{"", "secret"} {
+		if err = RunAgent("0.0.0.0:0", token, nil, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "token and TLS certificate") {
+			t.Errorf("non-loopback agent with token %q without TLS: %v", token, err)
+		}
+	}
+	if err = RunAgent("127.0.0.1:0", "", &TLSOptions{}, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "certificate and key") {
+		t.Errorf("agent TLS without certificate: %v", err)
+	}
+}
+
+func TestAgentConfigTLS(t *testing.T) {
+	fileName := writeTestFile(t, "ca.pem", "-----BEGIN CERTIFICATE-----\n")
+	u, _ := url.Parse("https://localhost/")
+	config := &Config{Url: u, Agents: []string{"a"}, TLS: &TLSOptions{ServerName: "service", CAFile: fileName}}
+	agent, err := NewAgentConfig(config, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agent.TLS.CAFile != "" || string(agent.TLS.CA) != "-----BEGIN CERTIFICATE-----\n" || agent.TLS.ServerName != "service" {
+		t.Errorf("agent TLS %+v", agent.TLS)
+	}
+	if config.TLS.CAFile != fileName || config.TLS.CA != nil {
+		t.Error("controller TLS options are changed")
+	}
+	//Agent does not read files named by controller
+	agent.TLS.CertFile = "/etc/passwd"
+	result, err := agent.Config(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TLS.CertFile != "" || string(result.TLS.CA) != string(agent.TLS.CA) {
+		t.Errorf("agent test TLS %+v", result.TLS)
+	}
+	config.TLS.KeyFile = "not-exists.pem"
+	if _, err = NewAgentConfig(config, 0); err == nil {
+		t.Error("missing key file: expected error")
+	}
+}
+
+func TestRunAgentsTLS(t *testing.T) {
+	ca := newTestCert(t, "test CA", nil)
+	agentCert := newTestCert(t, "127.0.0.1", ca)
+	tlsConfig, err := NewTLSConfig(&TLSOptions{Cert: agentCert.certPEM, Key: agentCert.keyPEM}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := startTestAgent(t, "secret", tlsConfig)
+	tests := []struct {
+		name     string
+		agentTLS *TLSOptions
+		err      string
+	}{
+		{"agent CA", &TLSOptions{CA: ca.certPEM}, ""},
+		{"unknown CA", &TLSOptions{CA: newTestCert(t, "other CA", nil).certPEM}, "certificate"},
+		{"plain link", nil, "agent"},
+	}
+	for _, test := range tests {
+		config := Config{
+			Url:         startTestServer(t, nil),
+			Header:      map[string][]string{"Authorization": {"Bearer secret"}},
+			Connections: 1,
+			Threads:     1,
+			MRQ:         -1,
+			Requests:    10,
+			Agents:      []string{agent},
+			AgentToken:  "secret",
+			AgentTLS:    test.agentTLS,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		report, err := Run(ctx, config)
+		cancel()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if report.Stats.Requests != 10 {
+			t.Errorf("%s: requests %d", test.name, report.Stats.Requests)
+		}
+	}
+}
+
+func TestDialAgentNotLoopback(t *testing.T) {
+	addrs, _ := net.InterfaceAddrs()
+	var ip net.IP
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && !network.IP.IsLoopback() && network.IP.To4() != nil {
+			ip = network.IP
+			break
+		}
+	}
+	if ip == nil {
+		t.Skip("no non-loopback address")
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+	go serveAgents(listener, "secret", ioutil.Discard)
+	//Config and token are not sent on plain link
+	if _, err = dialAgent(listener.Addr().String(), nil); err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Errorf("plain link to %s: %v", listener.Addr(), err)
+	}
+}
+
+func TestServeAgentConfigTimeout(t *testing.T) {
+	timeout := agentConfigTimeout
+	agentConfigTimeout = 100 * time.Millisecond
+	defer func() { agentConfigTimeout = timeout }()
+	controller, conn := net.Pipe()
+	defer controller.Close()
+	//Silent controller is disconnected
+	started := time.Now()
+	err := serveAgent(conn, "secret", ioutil.Discard)
+	if e, ok := err.(net.Error); !ok || !e.Timeout() || time.Now().Sub(started) > time.Second {
+		t.Errorf("error %v in %v", err, time.Now().Sub(started))
+	}
+}
+
+func TestCheckAgentStats(t *testing.T) {
+	config := &Config{Stages: Stages{{Duration: time.Second, Rate: 1}}, Groups: []*RequestGroup{{Name: "all", Weight: 1}}}
+	valid := &StatsSource{Stages: []*StageStats{nil}, Groups: []*GroupStats{{Name: "all"}}, Phases: NewPhaseStats(DefaultHistogramPrecision)}
+	if err := checkAgentStats(config, valid); err != nil {
+		t.Fatal(err)
+	}
+	for name, stats := range map[string]*StatsSource{
+		"unknown stage":  {Stages: []*StageStats{nil, nil}},
+		"unknown group":  {Groups: []*GroupStats{{}, {}}},
+		"empty group":    {Groups: []*GroupStats{nil}},
+		"partial phases": {Phases: PhaseStats{Write: NewHistogram(DefaultHistogramPrecision)}},
+	} {
+		if err := checkAgentStats(config, stats); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,766 @@
+package meter
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/a696385/go-meter/http"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	redialMinBackoff = time.Duration(50) * time.Millisecond
+	redialMaxBackoff = time.Duration(5) * time.Second
+)
+
+//Request or connect deadline is exceeded
+var errTimeout = errors.New("timeout")
+
+//Kinds of request errors
+const (
+	readError = iota
+	writeError
+	connectionError
+)
+
+//Connection of pool, requests are pipelined on its current conn
+type poolConnection struct {
+	id      int
+	lock    sync.Mutex
+	conn    net.Conn
+	manager *connectionManager
+
+	queue chan *queuedRequest
+	//Closed when current conn is broken
+	broken chan bool
+	//Requests sent by connection and 1 if budget is used
+	sent      int32
+	exhausted int32
+	//1 if connection left pool for the rest of test
+	retired int32
+	//1 if connection is out of pool after stage connections decrease
+	parked int32
+	//Queued requests without response or error
+	pending int32
+
+	responses chan *RequestStats
+}
+
+//Sent request waiting for response, done is set once on response or loss
+type queuedRequest struct {
+	req   *http.Request
+	group int
+	stage int
+	//Connection of queued request, nil before queueing
+	connection *poolConnection
+	done       int32
+	//Write start and end UnixNano and written bytes, set by writer after queueing
+	writeStart int64
+	writeEnd   int64
+	netOut     int64
+}
+
+type connectionManager struct {
+	conns  []*poolConnection
+	config *testRun
+	C      chan *poolConnection
+	closed int32
+	//Taken requests without response or error
+	inFlight int32
+	//Sent requests of -n budget, failed requests give budget back
+	reserved int64
+	//Responses of -n budget, test is done when all budget is answered
+	answered int64
+	//Connections taken while budget is reserved by in flight requests, returned to pool when budget is given back
+	waiting chan *poolConnection
+	//Connections with used -per-conn budget
+	retired int32
+	//Connections with lower id are in pool, changed by stages
+	active int32
+	//Closed when request budget is used
+	Done     chan bool
+	doneOnce sync.Once
+}
+
+func newConnectionManager(config *testRun) (result *connectionManager) {
+
+	result = &connectionManager{
+		config:  config,
+		conns:   make([]*poolConnection, config.Connections),
+		C:       make(chan *poolConnection, config.Connections),
+		Done:    make(chan bool),
+		waiting: make(chan *poolConnection, config.Connections),
+		active:  int32(config.Connections),
+	}
+	if config.Stages.HasConnections() {
+		result.active = int32(config.Stages.ConnectionsAt(config.Connections, 0))
+	}
+	for i := 0; i < config.Connections; i++ {
+		connection := &poolConnection{
+			id:        i,
+			manager:   result,
+			responses: config.requestStats,
+		}
+		result.conns[i] = connection
+		//Connection is dialed when stage needs it
+		if !connection.isActive() {
+			connection.parked = 1
+			continue
+		}
+		if config.Reconnect {
+			//Check host is available, connection will dial on every request
+			conn, _, _, err := connection.connect()
+			if err != nil {
+				config.countConnectionError(err)
+				fmt.Fprintf(config.Log, "ERROR: %s\n", err.Error())
+				if config.PerConnection > 0 {
+					connection.retire()
+				}
+			} else {
+				conn.Close()
+				connection.Return()
+			}
+			continue
+		}
+		if err := connection.Dial(); err != nil {
+			config.countConnectionError(err)
+			fmt.Fprintf(config.Log, "ERROR: %s\n", err.Error())
+			if config.PerConnection > 0 {
+				connection.retire()
+			} else {
+				go result.redial(connection)
+			}
+		} else {
+			connection.Return()
+		}
+	}
+	return
+}
+
+//Dial broken connection with backoff and return it to pool
+func (this *connectionManager) redial(connection *poolConnection) {
+	if this.dial(connection) {
+		connection.Return()
+	}
+}
+
+//Dial broken connection with backoff, false if manager is closed.
+//Connection of -per-conn budget is retired on dial error, so budget test is not waiting for it
+func (this *connectionManager) dial(connection *poolConnection) bool {
+	counters := &this.config.counters
+	backoff := redialMinBackoff
+	for !this.IsClosed() {
+		err := connection.Dial()
+		if err == nil {
+			atomic.AddInt32(&counters.Reconnects, 1)
+			return true
+		}
+		this.config.countConnectionError(err)
+		if this.config.PerConnection > 0 {
+			connection.retire()
+			return false
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > redialMaxBackoff {
+			backoff = redialMaxBackoff
+		}
+	}
+	return false
+}
+
+//Change count of active connections, connections out of count leave pool when taken or returned
+func (this *connectionManager) SetActive(count int) {
+	if count > len(this.conns) {
+		count = len(this.conns)
+	} else if count < 1 {
+		count = 1
+	}
+	old := int(atomic.SwapInt32(&this.active, int32(count)))
+	for i := old; i < count; i++ {
+		connection := this.conns[i]
+		if atomic.CompareAndSwapInt32(&connection.parked, 1, 0) {
+			go this.activate(connection)
+		}
+	}
+}
+
+//Dial parked connection and return it to pool, reconnect mode connection dials on every request
+func (this *connectionManager) activate(connection *poolConnection) {
+	if !this.config.Reconnect {
+		if err := connection.Dial(); err != nil {
+			this.config.countConnectionError(err)
+			if !this.dial(connection) {
+				return
+			}
+		}
+	}
+	connection.Return()
+}
+
+//Stop redial and close all connections, writers waiting for full queue are released
+func (this *connectionManager) Close() {
+	atomic.StoreInt32(&this.closed, 1)
+	for _, connection := range this.conns {
+		connection.lock.Lock()
+		if connection.conn != nil {
+			connection.conn.Close()
+			connection.conn = nil
+			close(connection.broken)
+		}
+		connection.lock.Unlock()
+	}
+}
+
+//Count of taken requests without response or error
+func (this *connectionManager) Pending() int {
+	return int(atomic.LoadInt32(&this.inFlight))
+}
+
+//Wait all in flight requests, false if stopped by signal
+func (this *connectionManager) Wait(stop <-chan struct{}) bool {
+	ticker := time.NewTicker(time.Duration(10) * time.Millisecond)
+	defer ticker.Stop()
+	for this.Pending() > 0 {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+//Request is answered or lost, in flight count is decremented once.
+//Returns false if request is already completed
+func (this *connectionManager) complete(item *queuedRequest) bool {
+	if !atomic.CompareAndSwapInt32(&item.done, 0, 1) {
+		return false
+	}
+	this.release(item)
+	return true
+}
+
+//Complete lost request, error is counted once by first completion
+func (this *connectionManager) completeError(item *queuedRequest, err error, kind int) {
+	if !atomic.CompareAndSwapInt32(&item.done, 0, 1) {
+		return
+	}
+	//Errors of closed test are not counted
+	if !this.IsClosed() {
+		this.config.countRequestError(item.group, item.stage, err, kind)
+	}
+	this.release(item)
+	this.giveBack()
+}
+
+//Decrement in flight counts of completed request
+func (this *connectionManager) release(item *queuedRequest) {
+	atomic.AddInt32(&this.inFlight, -1)
+	if item.connection != nil {
+		atomic.AddInt32(&item.connection.pending, -1)
+	}
+}
+
+//Reserve request of -n budget, false if budget is reserved by answered and in flight requests
+func (this *connectionManager) reserve() bool {
+	for {
+		reserved := atomic.LoadInt64(&this.reserved)
+		if reserved >= int64(this.config.Requests) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&this.reserved, reserved, reserved+1) {
+			return true
+		}
+	}
+}
+
+//Give back budget of failed or lost request, waiting connection sends it again
+func (this *connectionManager) giveBack() {
+	if this.config.Requests <= 0 {
+		return
+	}
+	atomic.AddInt64(&this.reserved, -1)
+	this.unhold()
+}
+
+//Count response of -n budget, test is done on last response
+func (this *connectionManager) answer() {
+	if this.config.Requests > 0 && atomic.AddInt64(&this.answered, 1) == int64(this.config.Requests) {
+		this.finish()
+		for this.unhold() {
+		}
+	}
+}
+
+//Keep connection out of pool until budget is given back or test is done
+func (this *connectionManager) hold(connection *poolConnection) {
+	this.waiting <- connection
+	//Budget could be given back before connection is waiting
+	if atomic.LoadInt64(&this.reserved) < int64(this.config.Requests) || atomic.LoadInt64(&this.answered) >= int64(this.config.Requests) {
+		this.unhold()
+	}
+}
+
+//Return one waiting connection to pool, false if no connection is waiting
+func (this *connectionManager) unhold() bool {
+	select {
+	case connection := <-this.waiting:
+		connection.Return()
+		return true
+	default:
+		return false
+	}
+}
+
+//Close Done channel once
+func (this *connectionManager) finish() {
+	this.doneOnce.Do(func() {
+		close(this.Done)
+	})
+}
+
+func (this *connectionManager) IsClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+//Open new tcp connection to config host, TLS handshake is done for https
+func (this *poolConnection) connect() (conn net.Conn, connectDuration, handshakeDuration time.Duration, err error) {
+	config := this.manager.config
+	host := config.Url.Host
+	if !strings.Contains(host, ":") {
+		if config.tlsConfig != nil {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+	c := time.Now()
+	conn, err = net.DialTimeout("tcp4", host, config.ConnectTimeout)
+	connectDuration = time.Now().Sub(c)
+	if err != nil || config.tlsConfig == nil {
+		return
+	}
+	tlsConn := tls.Client(conn, config.tlsConfig)
+	//Connect timeout includes TLS handshake
+	var deadline time.Time
+	if config.ConnectTimeout > 0 {
+		deadline = c.Add(config.ConnectTimeout)
+		conn.SetDeadline(deadline)
+	}
+	h := time.Now()
+	err = tlsConn.Handshake()
+	handshakeDuration = time.Now().Sub(h)
+	if err != nil {
+		conn.Close()
+		return nil, connectDuration, handshakeDuration, timeoutError(err, deadline)
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, connectDuration, handshakeDuration, nil
+}
+
+func (this *poolConnection) Dial() error {
+	if this.IsConnected() {
+		return nil
+	}
+	conn, connectDuration, handshakeDuration, err := this.connect()
+	if err != nil {
+		return err
+	}
+	queue := make(chan *queuedRequest, 120)
+	broken := make(chan bool)
+	this.lock.Lock()
+	this.conn = conn
+	this.queue = queue
+	this.broken = broken
+	this.lock.Unlock()
+
+	bf := bufio.NewReader(conn)
+	tp := textproto.NewReader(bf)
+
+	//Response resiver
+	go func(this *poolConnection) {
+		//Requests sent after broken response are not answered, they are lost by error of broken response
+		var cause error
+		defer func() {
+			this.manager.completeQueue(queue, cause)
+		}()
+		for {
+			var item *queuedRequest
+			select {
+			case item = <-queue:
+			case <-broken:
+				return
+			}
+			res, err := this.readResponse(conn, bf, tp, &item.writeEnd)
+			if err != nil {
+				//Timed out conn is recycled by next holder
+				cause = err
+				this.manager.completeError(item, err, readError)
+				this.fail(conn)
+				return
+			}
+			//Written bytes are unknown if response is read before write is completed
+			result := newRequestStats(item.req, res, loadTime(&item.writeStart), loadTime(&item.writeEnd), atomic.LoadInt64(&item.netOut))
+			result.Group = item.group
+			result.Stage = item.stage
+			//First response after dial carries connect stats
+			result.ConnectDuration = connectDuration
+			result.HandshakeDuration = handshakeDuration
+			connectDuration, handshakeDuration = 0, 0
+			this.responses <- result
+			this.manager.complete(item)
+			this.manager.answer()
+			//Server will close connection after response, parked connection is closed after last response
+			if hasToken(res.Header["Connection"], "close") || this.idleParked() {
+				this.fail(conn)
+				return
+			}
+		}
+	}(this)
+	return nil
+}
+
+//Read response with time to first byte and total response timeouts, total timeout is counted from now.
+//Time to first byte is counted from write end UnixNano, body can be still written when reading starts
+func (this *poolConnection) readResponse(conn net.Conn, bf *bufio.Reader, tp *textproto.Reader, writeEnd *int64) (*http.Response, error) {
+	config := this.manager.config
+	if config.FirstByteTimeout <= 0 && config.ResponseTimeout <= 0 {
+		return http.ReadResponse(bf, tp)
+	}
+	start := time.Now()
+	var deadline time.Time
+	if config.ResponseTimeout > 0 {
+		deadline = start.Add(config.ResponseTimeout)
+	}
+	if config.FirstByteTimeout > 0 && (deadline.IsZero() || config.FirstByteTimeout < config.ResponseTimeout) {
+		for {
+			//Wait next timeout period while request is written
+			written := loadTime(writeEnd)
+			firstByte := time.Now().Add(config.FirstByteTimeout)
+			if !written.IsZero() {
+				firstByte = written.Add(config.FirstByteTimeout)
+				if written.Before(start) {
+					firstByte = start.Add(config.FirstByteTimeout)
+				}
+			}
+			if !deadline.IsZero() && deadline.Before(firstByte) {
+				firstByte = deadline
+			}
+			conn.SetReadDeadline(firstByte)
+			_, err := bf.Peek(1)
+			if err == nil {
+				break
+			}
+			if err = timeoutError(err, firstByte); err != errTimeout || !written.IsZero() || firstByte.Equal(deadline) {
+				return nil, err
+			}
+		}
+	}
+	conn.SetReadDeadline(deadline)
+	res, err := http.ReadResponse(bf, tp)
+	if err != nil {
+		err = timeoutError(err, deadline)
+	}
+	return res, err
+}
+
+//Write request with write timeout
+func (this *poolConnection) writeRequest(conn net.Conn, req *http.Request) error {
+	timeout := this.manager.config.WriteTimeout
+	if timeout <= 0 {
+		return req.Write(conn)
+	}
+	deadline := time.Now().Add(timeout)
+	conn.SetWriteDeadline(deadline)
+	if err := req.Write(conn); err != nil {
+		return timeoutError(err, deadline)
+	}
+	return nil
+}
+
+//Error is errTimeout if deadline is exceeded, http reader hides net errors
+func timeoutError(err error, deadline time.Time) error {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return errTimeout
+	}
+	return err
+}
+
+//Increment counter or timeout errors for timeout
+func (this *counters) countError(err error, counter *int32) {
+	if netErr, ok := err.(net.Error); err == errTimeout || ok && netErr.Timeout() {
+		atomic.AddInt32(&this.TimeoutErrors, 1)
+		return
+	}
+	atomic.AddInt32(counter, 1)
+}
+
+//Count request error of kind to test, request group and stage counters
+func (this *testRun) countRequestError(group int, stage int, err error, kind int) {
+	this.counters.countKind(err, kind)
+	if group < len(this.groupCounters) {
+		this.groupCounters[group].countKind(err, kind)
+	}
+	if stage < len(this.stageCounters) {
+		this.stageCounters[stage].countKind(err, kind)
+	}
+}
+
+//Count dial error to test and current stage counters
+func (this *testRun) countConnectionError(err error) {
+	this.counters.countKind(err, connectionError)
+	if stage := this.stageAt(time.Now()); stage < len(this.stageCounters) {
+		this.stageCounters[stage].countKind(err, connectionError)
+	}
+}
+
+func (this *counters) countKind(err error, kind int) {
+	switch kind {
+	case writeError:
+		this.countError(err, &this.WriteErrors)
+	case connectionError:
+		this.countError(err, &this.ConnectionErrors)
+	default:
+		this.countError(err, &this.ReadErrors)
+	}
+}
+
+//Close broken conn, conn is ignored if already replaced.
+//Connection is redialed by whoever holds it next from pool
+func (this *poolConnection) fail(conn net.Conn) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.conn != conn {
+		return
+	}
+	this.conn.Close()
+	this.conn = nil
+	close(this.broken)
+}
+
+//Complete requests left in queue of broken conn, they are not answered.
+//Requests behind timed out response are timeouts, other requests are read errors
+func (this *connectionManager) completeQueue(queue chan *queuedRequest, cause error) {
+	for {
+		select {
+		case item := <-queue:
+			this.completeError(item, cause, readError)
+		default:
+			return
+		}
+	}
+}
+
+func hasToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Duration is measured from write start, or from intended send time in open model, to body end.
+//Write end is first byte time if response is read before write is completed
+func newRequestStats(req *http.Request, res *http.Response, writeStart time.Time, writeEnd time.Time, netOut int64) *RequestStats {
+	if writeEnd.IsZero() || writeEnd.After(res.FirstByte) {
+		writeEnd = res.FirstByte
+	}
+	if writeStart.IsZero() || writeStart.After(writeEnd) {
+		writeStart = writeEnd
+	}
+	start := writeStart
+	if !req.Created.IsZero() {
+		start = req.Created
+	}
+	result := &RequestStats{}
+	result.Duration = res.BodyDone.Sub(start)
+	result.Write = writeEnd.Sub(writeStart)
+	result.Wait = res.FirstByte.Sub(writeEnd)
+	result.Headers = res.HeadersDone.Sub(res.FirstByte)
+	result.Body = res.BodyDone.Sub(res.HeadersDone)
+	result.NetOut = netOut
+	result.NetIn = res.BufferSize
+	result.ResponseCode = res.StatusCode
+	return result
+}
+
+//Time of UnixNano set by other goroutine, zero time if not set
+func loadTime(value *int64) time.Time {
+	if nano := atomic.LoadInt64(value); nano > 0 {
+		return time.Unix(0, nano)
+	}
+	return time.Time{}
+}
+
+//Leave pool for the rest of test, budget test is done when all connections are retired
+func (this *poolConnection) retire() {
+	if !atomic.CompareAndSwapInt32(&this.retired, 0, 1) {
+		return
+	}
+	if int(atomic.AddInt32(&this.manager.retired, 1)) == len(this.manager.conns) {
+		this.manager.finish()
+	}
+}
+
+//Connection is in pool while its id is lower than active connections count
+func (this *poolConnection) isActive() bool {
+	return this.id < int(atomic.LoadInt32(&this.manager.active))
+}
+
+//Leave pool until stage activates connection again, conn is closed when responses are read
+func (this *poolConnection) park() {
+	atomic.StoreInt32(&this.parked, 1)
+	if atomic.LoadInt32(&this.pending) == 0 {
+		this.lock.Lock()
+		conn := this.conn
+		this.lock.Unlock()
+		if conn != nil {
+			this.fail(conn)
+		}
+	}
+}
+
+//Connection is parked and has no queued requests
+func (this *poolConnection) idleParked() bool {
+	return atomic.LoadInt32(&this.pending) == 0 && atomic.LoadInt32(&this.parked) == 1
+}
+
+func (this *poolConnection) IsConnected() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.conn != nil
+}
+
+//Take connection for request, false if request budget is reserved or connection is parked.
+//Connection waits out of pool while budget is reserved, parked connection is activated by stages.
+//Connection is not returned to pool after last request of -per-conn budget
+func (this *poolConnection) Take() bool {
+	manager := this.manager
+	config := manager.config
+	if !this.isActive() {
+		this.park()
+		return false
+	}
+	if config.Requests > 0 && !manager.reserve() {
+		manager.hold(this)
+		return false
+	}
+	if config.PerConnection > 0 && atomic.AddInt32(&this.sent, 1) == int32(config.PerConnection) {
+		atomic.StoreInt32(&this.exhausted, 1)
+	}
+	atomic.AddInt32(&manager.inFlight, 1)
+	return true
+}
+
+func (this *poolConnection) Return() {
+	if atomic.LoadInt32(&this.exhausted) == 1 {
+		this.retire()
+		return
+	}
+	if !this.isActive() {
+		this.park()
+		return
+	}
+	this.manager.C <- this
+}
+
+//Send request of group, response is read by connection receiver
+func (this *poolConnection) Exec(req *http.Request, group int) {
+	if this.manager.config.Reconnect {
+		this.execReconnect(req, group)
+		return
+	}
+	config := this.manager.config
+	//Requests of all groups are pipelined, response is counted to group of queued request
+	item := &queuedRequest{req: req, group: group, stage: config.requestStage(req)}
+	for {
+		this.lock.Lock()
+		conn, queue, broken := this.conn, this.queue, this.broken
+		this.lock.Unlock()
+		if conn == nil {
+			//Request is sent after reconnect
+			if !this.manager.dial(this) {
+				//Request is lost, budget is sent by other connection
+				if this.manager.complete(item) {
+					this.manager.giveBack()
+				}
+				return
+			}
+			continue
+		}
+
+		item.connection = this
+		atomic.AddInt32(&this.pending, 1)
+		select {
+		case queue <- item:
+		case <-broken:
+			item.connection = nil
+			atomic.AddInt32(&this.pending, -1)
+			continue
+		}
+		//Write phase starts after request is queued, time blocked by full queue is not counted
+		atomic.StoreInt64(&item.writeStart, time.Now().UnixNano())
+		err := this.writeRequest(conn, req)
+		atomic.StoreInt64(&item.netOut, req.BufferSize)
+		atomic.StoreInt64(&item.writeEnd, time.Now().UnixNano())
+		if err != nil {
+			//Request is in queue, it is counted once by writer or receiver
+			this.manager.completeError(item, err, writeError)
+			this.fail(conn)
+			this.manager.redial(this)
+		} else {
+			this.Return()
+		}
+		return
+	}
+}
+
+//Dial, send request, read response and close connection
+func (this *poolConnection) execReconnect(req *http.Request, group int) {
+	defer this.Return()
+	defer atomic.AddInt32(&this.manager.inFlight, -1)
+	config := this.manager.config
+	stage := config.requestStage(req)
+
+	conn, connectDuration, handshakeDuration, err := this.connect()
+	if err != nil {
+		config.countRequestError(group, stage, err, connectionError)
+		this.manager.giveBack()
+		return
+	}
+	defer conn.Close()
+
+	req.Header["Connection"] = []string{"close"}
+	writeStart := time.Now()
+	if err = this.writeRequest(conn, req); err != nil {
+		config.countRequestError(group, stage, err, writeError)
+		this.manager.giveBack()
+		return
+	}
+	writeEnd := time.Now()
+	written := writeEnd.UnixNano()
+	bf := bufio.NewReader(conn)
+	res, err := this.readResponse(conn, bf, textproto.NewReader(bf), &written)
+	if err != nil {
+		config.countRequestError(group, stage, err, readError)
+		this.manager.giveBack()
+		return
+	}
+	result := newRequestStats(req, res, writeStart, writeEnd, req.BufferSize)
+	result.Group = group
+	result.Stage = stage
+	result.ConnectDuration = connectDuration
+	result.HandshakeDuration = handshakeDuration
+	this.responses <- result
+	this.manager.answer()
+}
@@ -0,0 +1,392 @@
+package meter
+
+import (
+	"context"
+	"errors"
+	"github.com/a696385/go-meter/http"
+	"io"
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//Start test target, handler is nil for empty 200 responses
+func startTestServer(t *testing.T, handler nethttp.HandlerFunc) *url.URL {
+	if handler == nil {
+		handler = func(w nethttp.ResponseWriter, r *nethttp.Request) {}
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	return u
+}
+
+//Run test or fail if it is not completed in time
+func runTest(t *testing.T, config Config, timeout time.Duration) Report {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	report, err := Run(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Interrupted {
+		t.Fatalf("test is not completed in %v", timeout)
+	}
+	return report
+}
+
+func TestPerConnectionBudget(t *testing.T) {
+	config := Config{
+		Url:           startTestServer(t, nil),
+		Connections:   4,
+		Threads:       2,
+		PerConnection: 10,
+	}
+	stats := runTest(t, config, 5*time.Second).Stats
+	if stats.Requests != 40 || stats.Codes[200] != 40 {
+		t.Errorf("requests %d, codes %v", stats.Requests, stats.Codes)
+	}
+}
+
+//Listener accepts one connection, next connections are refused
+type oneConnListener struct {
+	net.Listener
+}
+
+func (this oneConnListener) Accept() (net.Conn, error) {
+	conn, err := this.Listener.Accept()
+	this.Listener.Close()
+	return conn, err
+}
+
+func TestPerConnectionBudgetConnectError(t *testing.T) {
+	for _, reconnect := range []bool{false, true} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &nethttp.Server{Handler: nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {})}
+		go server.Serve(oneConnListener{listener})
+		u, _ := url.Parse("http://" + listener.Addr().String())
+		config := Config{
+			Url:           u,
+			Connections:   3,
+			Threads:       1,
+			PerConnection: 10,
+			Reconnect:     reconnect,
+		}
+		//Connections are retired on connect error, test is not waiting them
+		stats := runTest(t, config, 5*time.Second).Stats
+		server.Close()
+		if stats.ConnectionErrors == 0 {
+			t.Errorf("reconnect %v: no connection errors", reconnect)
+		}
+		if !reconnect && stats.Codes[200] != 10 {
+			t.Errorf("requests %d, codes %v", stats.Requests, stats.Codes)
+		}
+	}
+}
+
+func TestRequestsBudget(t *testing.T) {
+	config := Config{
+		Url:         startTestServer(t, nil),
+		Connections: 4,
+		Threads:     4,
+		MRQ:         -1,
+		Requests:    50,
+	}
+	run, err := config.prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = run.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer run.manager.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !runLoad(ctx, run, 0) {
+		t.Fatal("test is not completed in 5s")
+	}
+	if run.stats.Requests != 50 {
+		t.Errorf("requests %d", run.stats.Requests)
+	}
+	//Connections are returned to pool after budget is used
+	if pool := len(run.manager.C); pool != config.Connections {
+		t.Errorf("%d connections in pool", pool)
+	}
+}
+
+func TestRequestsBudgetErrors(t *testing.T) {
+	for _, reconnect := range []bool{false, true} {
+		var requests int32
+		config := Config{
+			Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				//Every third request is closed without response
+				if atomic.AddInt32(&requests, 1)%3 == 0 {
+					conn, _, _ := w.(nethttp.Hijacker).Hijack()
+					conn.Close()
+				}
+			}),
+			Connections: 2,
+			Threads:     2,
+			MRQ:         -1,
+			Requests:    30,
+			Reconnect:   reconnect,
+		}
+		//Budget counts responses, failed requests are sent again
+		stats := runTest(t, config, 5*time.Second).Stats
+		errors := stats.ReadErrors + stats.WriteErrors + stats.TimeoutErrors + stats.ConnectionErrors
+		if stats.Requests != 30 || stats.Codes[200] != 30 || stats.ReadErrors == 0 {
+			t.Errorf("reconnect %v: requests %d, codes %v, read errors %d", reconnect, stats.Requests, stats.Codes, stats.ReadErrors)
+		}
+		//Every closed request is an error, requests in flight after last response are answered too
+		if received := int(atomic.LoadInt32(&requests)); received-received/3 < 30 || errors < received/3 {
+			t.Errorf("reconnect %v: server got %d requests, errors %d", reconnect, received, errors)
+		}
+	}
+}
+
+//Stats errors of test
+func testErrors(stats *StatsSource) (read, write, timeout int) {
+	return stats.ReadErrors, stats.WriteErrors, stats.TimeoutErrors
+}
+
+func TestFirstByteTimeout(t *testing.T) {
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			time.Sleep(300 * time.Millisecond)
+		}),
+		Connections:      1,
+		Threads:          1,
+		MRQ:              -1,
+		FirstByteTimeout: 50 * time.Millisecond,
+		Duration:         200 * time.Millisecond,
+	}
+	//Pipelined requests behind timed out request are timeouts too
+	stats := runTest(t, config, 5*time.Second).Stats
+	if read, write, timeout := testErrors(stats); stats.Requests != 0 || read != 0 || write != 0 || timeout < 2 {
+		t.Errorf("requests %d, read %d, write %d, timeout %d", stats.Requests, read, write, timeout)
+	}
+}
+
+func TestFirstByteTimeoutLargeBody(t *testing.T) {
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			//Body is read slowly, its write takes longer than first byte timeout
+			time.Sleep(300 * time.Millisecond)
+			io.Copy(ioutil.Discard, r.Body)
+		}),
+		Method:           "POST",
+		Source:           &Source{Data: [][]byte{make([]byte, 32<<20)}},
+		Connections:      1,
+		Threads:          1,
+		MRQ:              -1,
+		Requests:         2,
+		FirstByteTimeout: 100 * time.Millisecond,
+	}
+	//Time to first byte is counted from write end, not from start of reading
+	stats := runTest(t, config, 5*time.Second).Stats
+	if read, write, timeout := testErrors(stats); stats.Requests != 2 || read != 0 || write != 0 || timeout != 0 {
+		t.Errorf("requests %d, read %d, write %d, timeout %d", stats.Requests, read, write, timeout)
+	}
+}
+
+func TestResponseTimeout(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		first string
+	)
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			lock.Lock()
+			if first == "" {
+				first = r.RemoteAddr
+			}
+			slow := first == r.RemoteAddr
+			lock.Unlock()
+			if !slow {
+				return
+			}
+			//First byte is in time, body is late on first connection
+			w.Header().Set("Content-Length", "10")
+			w.WriteHeader(200)
+			w.(nethttp.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+		}),
+		Connections:     1,
+		Threads:         1,
+		MRQ:             -1,
+		Requests:        3,
+		ResponseTimeout: 100 * time.Millisecond,
+	}
+	//Every request is counted once, budget of failed requests is sent again on new connection
+	stats := runTest(t, config, 5*time.Second).Stats
+	if read, write, timeout := testErrors(stats); stats.Requests != 3 || read != 0 || write != 0 || timeout != 3 {
+		t.Errorf("requests %d, read %d, write %d, timeout %d", stats.Requests, read, write, timeout)
+	}
+}
+
+//Listener holds first connection without reading it, next connections are accepted
+type stuckListener struct {
+	net.Listener
+	stuck chan net.Conn
+}
+
+func (this stuckListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := this.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case this.stuck <- conn:
+		default:
+			return conn, nil
+		}
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	//Target never reads requests of first connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck := make(chan net.Conn, 1)
+	server := &nethttp.Server{Handler: nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+	})}
+	go server.Serve(stuckListener{listener, stuck})
+	defer func() {
+		server.Close()
+		close(stuck)
+		for conn := range stuck {
+			conn.Close()
+		}
+	}()
+	u, _ := url.Parse("http://" + listener.Addr().String())
+	config := Config{
+		Url:          u,
+		Method:       "POST",
+		Source:       &Source{Data: [][]byte{[]byte("b"), make([]byte, 64<<20), []byte("a")}},
+		Connections:  1,
+		Threads:      1,
+		MRQ:          -1,
+		Requests:     2,
+		WriteTimeout: 100 * time.Millisecond,
+	}
+	//Large body is sent first, failed write is not counted again by response receiver, small requests are sent on next connection
+	stats := runTest(t, config, 5*time.Second).Stats
+	if read, write, timeout := testErrors(stats); stats.Requests != 2 || read != 0 || write != 0 || timeout != 1 {
+		t.Errorf("requests %d, read %d, write %d, timeout %d", stats.Requests, read, write, timeout)
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	other := errors.New("other")
+	if timeoutError(other, time.Time{}) != other || timeoutError(other, time.Now().Add(time.Hour)) != other {
+		t.Error("error before deadline is not timeout")
+	}
+	if timeoutError(other, time.Now().Add(-time.Millisecond)) != errTimeout {
+		t.Error("error after deadline is timeout")
+	}
+}
+
+func TestNewRequestStats(t *testing.T) {
+	base := time.Now()
+	at := func(ms int) time.Time {
+		return base.Add(time.Duration(ms) * time.Millisecond)
+	}
+	res := &http.Response{StatusCode: 200, BufferSize: 100, FirstByte: at(30), HeadersDone: at(35), BodyDone: at(50)}
+	tests := []struct {
+		name                  string
+		created               time.Time
+		writeStart, writeEnd  time.Time
+		duration, write, wait int
+	}{
+		{"written", time.Time{}, at(10), at(20), 40, 10, 10},
+		{"open model", at(0), at(10), at(20), 50, 10, 10},
+		{"response before write end", time.Time{}, at(10), time.Time{}, 40, 20, 0},
+		{"response before write start", time.Time{}, time.Time{}, time.Time{}, 20, 0, 0},
+	}
+	for _, test := range tests {
+		req := &http.Request{Created: test.created}
+		result := newRequestStats(req, res, test.writeStart, test.writeEnd, 10)
+		ms := func(ms int) time.Duration {
+			return time.Duration(ms) * time.Millisecond
+		}
+		if result.Duration != ms(test.duration) || result.Write != ms(test.write) || result.Wait != ms(test.wait) ||
+			result.Headers != ms(5) || result.Body != ms(15) || result.NetOut != 10 || result.NetIn != 100 || result.ResponseCode != 200 {
+			t.Errorf("%s: %+v", test.name, result)
+		}
+	}
+}
+
+func TestReconnectConnectTime(t *testing.T) {
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			time.Sleep(20 * time.Millisecond)
+		}),
+		Connections: 2,
+		Threads:     1,
+		MRQ:         -1,
+		Requests:    10,
+		Reconnect:   true,
+	}
+	stats := runTest(t, config, 5*time.Second).Stats
+	if stats.Requests != 10 || stats.Codes[200] != 10 {
+		t.Fatalf("requests %d, codes %v", stats.Requests, stats.Codes)
+	}
+	//Every request dials, connect time is not part of request latency
+	if stats.Connect.Count != 10 || stats.Handshake.Count != 0 {
+		t.Errorf("connect %+v, handshake %+v", stats.Connect, stats.Handshake)
+	}
+	if min := stats.Latency.Min(); min < 20*time.Millisecond || stats.Connect.Max >= min {
+		t.Errorf("latency min %v, connect max %v", min, stats.Connect.Max)
+	}
+	if phases := stats.Phases.Wait.Min(); phases < 20*time.Millisecond {
+		t.Errorf("wait phase min %v", phases)
+	}
+}
+
+func TestRedialClosedConnection(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler nethttp.HandlerFunc
+		errors  bool
+	}{
+		{"connection close header", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			w.Header().Set("Connection", "close")
+		}, false},
+		{"closed without header", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			conn, buf, _ := w.(nethttp.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+			buf.Flush()
+			conn.Close()
+		}, true},
+	}
+	for _, test := range tests {
+		config := Config{
+			Url:         startTestServer(t, test.handler),
+			Connections: 1,
+			Threads:     1,
+			Rate:        50,
+			Duration:    500 * time.Millisecond,
+		}
+		stats := runTest(t, config, 5*time.Second).Stats
+		//Connection is dialed again by next request
+		if stats.Requests < 10 || stats.Reconnects < stats.Requests-1 || stats.Codes[200] != stats.Requests {
+			t.Errorf("%s: requests %d, reconnects %d, codes %v", test.name, stats.Requests, stats.Reconnects, stats.Codes)
+		}
+		if errors := stats.ReadErrors + stats.WriteErrors + stats.ConnectionErrors; !test.errors && errors != 0 {
+			t.Errorf("%s: %d errors", test.name, errors)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package meter
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+//Agent connect timeout
+const agentDialTimeout = time.Duration(5) * time.Second
+
+//Controller connection to agent
+type agentSession struct {
+	addr    string
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+//Message or error from agent
+type agentEvent struct {
+	session *agentSession
+	message *agentMessage
+	err     error
+}
+
+//Push config to agents, start them together and merge their stats to test statistic.
+//Returns error if any agent failed, stats of completed agents are merged anyway
+func runController(ctx context.Context, config *testRun) error {
+	sessions := make([]*agentSession, len(config.Agents))
+	defer func() {
+		for _, session := range sessions {
+			if session != nil {
+				session.conn.Close()
+			}
+		}
+	}()
+	//Prepare all agents before start
+	for i, addr := range config.Agents {
+		conn, err := dialAgent(addr, config.AgentTLS)
+		if err != nil {
+			return fmt.Errorf("agent %s %v", addr, err)
+		}
+		session := &agentSession{
+			addr:    addr,
+			conn:    conn,
+			encoder: json.NewEncoder(conn),
+			decoder: json.NewDecoder(conn),
+		}
+		sessions[i] = session
+		agent, err := NewAgentConfig(config.Config, i)
+		if err != nil {
+			return err
+		}
+		if err = session.encoder.Encode(&agentMessage{Type: agentConfigMessage, Version: agentProtocolVersion, Token: config.AgentToken, Config: agent}); err != nil {
+			return fmt.Errorf("agent %s %v", addr, err)
+		}
+	}
+	for _, session := range sessions {
+		message := agentMessage{}
+		if err := session.decoder.Decode(&message); err != nil {
+			return fmt.Errorf("agent %s %v", session.addr, err)
+		}
+		if message.Type == agentErrorMessage {
+			return fmt.Errorf("agent %s %s", session.addr, message.Error)
+		}
+		if message.Type != agentReadyMessage {
+			return fmt.Errorf("agent %s unexpected message %s", session.addr, message.Type)
+		}
+	}
+
+	//Agents wait start time, so they start together regardless of send order
+	events := make(chan agentEvent, len(sessions))
+	config.started = time.Now().Add(agentStartDelay)
+	for _, session := range sessions {
+		if err := session.encoder.Encode(&agentMessage{Type: agentStartMessage, Start: config.started.UnixNano()}); err != nil {
+			return fmt.Errorf("agent %s %v", session.addr, err)
+		}
+	}
+	for _, session := range sessions {
+		go session.read(events)
+	}
+
+	if config.Verbose {
+		printPerSecondHeader(config)
+	}
+	//Stats of seconds not reported by all agents yet
+	seconds := map[time.Duration]*agentSecond{}
+	//Requests count of printed seconds
+	total := 0
+	failed := 0
+	running := len(sessions)
+	stop := ctx.Done()
+	//Print seconds reported by all running agents in order
+	flush := func() {
+		for len(seconds) > 0 {
+			var first time.Duration = -1
+			for second := range seconds {
+				if first == -1 || second < first {
+					first = second
+				}
+			}
+			if seconds[first].agents < running {
+				return
+			}
+			total += seconds[first].stats.Requests
+			printPerSecond(config, first, total, &seconds[first].stats)
+			delete(seconds, first)
+		}
+	}
+	for running > 0 {
+		select {
+		case <-stop:
+			for _, session := range sessions {
+				session.encoder.Encode(&agentMessage{Type: agentStopMessage})
+			}
+			stop = nil
+		case event := <-events:
+			switch {
+			case event.err != nil:
+				fmt.Fprintf(config.Log, "ERROR: Agent %s %v\n", event.session.addr, event.err)
+				failed++
+				running--
+			case event.message.Type == agentStatsMessage && event.message.Stats != nil:
+				second := seconds[event.message.Second]
+				if second == nil {
+					second = &agentSecond{stats: newStatsSourcePerSecond(NewHistogram(config.Precision))}
+					seconds[event.message.Second] = second
+				}
+				second.agents++
+				second.stats.Merge(event.message.Stats)
+				if config.Metrics != nil {
+					config.Metrics.AddSecond(event.message.Stats)
+				}
+			case event.message.Type == agentDoneMessage && event.message.Result != nil:
+				if err := checkAgentStats(config.Config, event.message.Result); err != nil {
+					fmt.Fprintf(config.Log, "ERROR: Agent %s %v\n", event.session.addr, err)
+					failed++
+				} else {
+					config.stats.Merge(event.message.Result)
+				}
+				running--
+			}
+			flush()
+		}
+	}
+	//Seconds of failed or stopped agents
+	running = 0
+	flush()
+	if config.Verbose {
+		printPerSecondFooter(config)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d agents failed", failed, len(sessions))
+	}
+	return nil
+}
+
+//Connect agent, link to non-loopback agent must be TLS, config and token are secrets
+func dialAgent(addr string, tlsOptions *TLSOptions) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, agentDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if tlsOptions == nil {
+		if ip, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !ip.IP.IsLoopback() {
+			conn.Close()
+			return nil, errors.New("link is not encrypted, agent CA is required for non-loopback agents")
+		}
+		return conn, nil
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	tlsConfig, err := NewTLSConfig(tlsOptions, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(agentDialTimeout))
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+//Check agent stats match test, broken stats of agent must not panic controller
+func checkAgentStats(config *Config, stats *StatsSource) error {
+	if len(stats.Stages) > len(config.Stages) || len(stats.Groups) > len(config.Groups) {
+		return errors.New("stats have unknown stages or groups")
+	}
+	for _, group := range stats.Groups {
+		if group == nil {
+			return errors.New("stats of group are empty")
+		}
+	}
+	if phases := stats.Phases; phases.Write != nil && (phases.Wait == nil || phases.Headers == nil || phases.Body == nil) {
+		return errors.New("stats of phases are not complete")
+	}
+	return nil
+}
+
+//Per second stats merged from agents
+type agentSecond struct {
+	agents int
+	stats  StatsSourcePerSecond
+}
+
+//Read agent messages until test is done
+func (this *agentSession) read(events chan agentEvent) {
+	for {
+		message := &agentMessage{}
+		err := this.decoder.Decode(message)
+		switch {
+		case err != nil:
+			events <- agentEvent{session: this, err: err}
+			return
+		case message.Type == agentErrorMessage:
+			events <- agentEvent{session: this, err: errors.New(message.Error)}
+			return
+		}
+		events <- agentEvent{session: this, message: message}
+		if message.Type == agentDoneMessage {
+			return
+		}
+	}
+}
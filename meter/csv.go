@@ -0,0 +1,91 @@
+package meter
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+//Per second percentiles in CSV
+var csvPercentiles = []float64{50, 90, 99}
+
+//Per second time series writer, durations are in milliseconds
+type CSVWriter struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+func NewCSVWriter(fileName string) (*CSVWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	result := &CSVWriter{
+		file: file,
+		w:    csv.NewWriter(file),
+	}
+	header := []string{"second", "total", "requests", "skipped", "mean_ms"}
+	for _, percentile := range csvPercentiles {
+		header = append(header, "p"+strconv.FormatFloat(percentile, 'f', -1, 64)+"_ms")
+	}
+	header = append(header, "max_ms", "bytes_in", "bytes_out",
+		"connection_errors", "read_errors", "write_errors", "timeout_errors",
+		"codes_1xx", "codes_2xx", "codes_3xx", "codes_4xx", "codes_5xx", "codes_other", "codes")
+	if err = result.w.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+//Write one second row
+func (this *CSVWriter) Write(second time.Duration, total int, stats *StatsSourcePerSecond) error {
+	row := []string{
+		strconv.Itoa(int(second.Seconds())),
+		strconv.Itoa(total),
+		strconv.Itoa(stats.Requests),
+		strconv.Itoa(stats.Skiped),
+		formatMilliseconds(stats.Latency.Mean()),
+	}
+	for _, percentile := range csvPercentiles {
+		row = append(row, formatMilliseconds(stats.Latency.Percentile(percentile)))
+	}
+	row = append(row,
+		formatMilliseconds(stats.Latency.Max()),
+		strconv.FormatInt(stats.Readed, 10),
+		strconv.FormatInt(stats.Writed, 10),
+		strconv.Itoa(stats.Errors.Connection),
+		strconv.Itoa(stats.Errors.Read),
+		strconv.Itoa(stats.Errors.Write),
+		strconv.Itoa(stats.Errors.Timeout),
+	)
+	//Group HTTP codes by class
+	classes := make([]int, 6)
+	for code, count := range stats.Codes {
+		class := code / 100
+		if class < 1 || class > 5 {
+			class = 6
+		}
+		classes[class-1] += count
+	}
+	for _, count := range classes {
+		row = append(row, strconv.Itoa(count))
+	}
+	//Count of every code like "200:95 503:5"
+	row = append(row, codesString(stats.Codes))
+	if err := this.w.Write(row); err != nil {
+		return err
+	}
+	this.w.Flush()
+	return this.w.Error()
+}
+
+func (this *CSVWriter) Close() error {
+	this.w.Flush()
+	return this.file.Close()
+}
+
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatFloat(milliseconds(d), 'f', 3, 64)
+}
@@ -0,0 +1,109 @@
+package meter
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Read CSV rows of file
+func readTestCSV(t *testing.T, fileName string) [][]string {
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestCSVWriter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "seconds.csv")
+	writer, err := NewCSVWriter(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := newStatsSourcePerSecond(NewHistogram(DefaultHistogramPrecision))
+	stats.Requests, stats.Skiped, stats.Readed, stats.Writed = 4, 1, 400, 100
+	stats.Errors = ErrorCounters{Connection: 1, Read: 2, Write: 3, Timeout: 4}
+	stats.Codes = map[int]int{200: 2, 302: 1, 503: 1, 600: 1}
+	for _, ms := range []int{10, 20, 30, 40} {
+		stats.Latency.Record(time.Duration(ms) * time.Millisecond)
+	}
+	if err = writer.Write(time.Second, 4, &stats); err != nil {
+		t.Fatal(err)
+	}
+	//Second without responses
+	empty := newStatsSourcePerSecond(NewHistogram(DefaultHistogramPrecision))
+	if err = writer.Write(2*time.Second, 4, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readTestCSV(t, fileName)
+	p50, p90 := formatMilliseconds(stats.Latency.Percentile(50)), formatMilliseconds(stats.Latency.Percentile(90))
+	want := [][]string{
+		{"second", "total", "requests", "skipped", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "max_ms", "bytes_in", "bytes_out",
+			"connection_errors", "read_errors", "write_errors", "timeout_errors",
+			"codes_1xx", "codes_2xx", "codes_3xx", "codes_4xx", "codes_5xx", "codes_other", "codes"},
+		{"1", "4", "4", "1", "25.000", p50, p90, "40.000", "40.000", "400", "100",
+			"1", "2", "3", "4", "0", "2", "1", "0", "1", "1", "200:2 302:1 503:1 600:1"},
+		{"2", "4", "0", "0", "0.000", "0.000", "0.000", "0.000", "0.000", "0", "0",
+			"0", "0", "0", "0", "0", "0", "0", "0", "0", "0", ""},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows %v", rows)
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d:\n%v\nwant:\n%v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestRunCSV(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "seconds.csv")
+	writer, err := NewCSVWriter(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Url:         startTestServer(t, nil),
+		Connections: 1,
+		Threads:     1,
+		Rate:        20,
+		Duration:    2100 * time.Millisecond,
+		CSV:         writer,
+	}
+	stats := runTest(t, config, 5*time.Second).Stats
+	writer.Close()
+	//Row of every completed second, total is running count
+	rows := readTestCSV(t, fileName)
+	if len(rows) < 3 {
+		t.Fatalf("rows %v", rows)
+	}
+	total := 0
+	for i, row := range rows[1:] {
+		requests, _ := strconv.Atoi(row[2])
+		total += requests
+		if row[0] != strconv.Itoa(i+1) || row[1] != strconv.Itoa(total) || requests < 15 || requests > 25 || row[16] != row[2] {
+			t.Errorf("row %d: %v", i+1, row)
+		}
+	}
+	if total > stats.Requests {
+		t.Errorf("rows total %d, requests %d", total, stats.Requests)
+	}
+	if data, _ := ioutil.ReadFile(fileName); !strings.HasSuffix(string(data), "\n") {
+		t.Error("last row is not flushed")
+	}
+}
@@ -0,0 +1,193 @@
+package meter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Max length of group schedule, sum of weights divided by their common divisor
+const maxGroupSchedule = 10000
+
+//Named requests with weight, group is selected for every request by weight
+type RequestGroup struct {
+	Name   string
+	Weight int
+	//Requests of group, nil for config URL
+	Source *Source
+}
+
+//Statistic of one request group
+type GroupStats struct {
+	Name     string
+	Weight   int
+	Requests int
+	Readed   int64
+	Writed   int64
+	Latency  *Histogram
+	Codes    map[int]int
+	Errors   ErrorCounters
+}
+
+func (this *GroupStats) Merge(other *GroupStats) {
+	this.Requests += other.Requests
+	this.Readed += other.Readed
+	this.Writed += other.Writed
+	if other.Latency != nil {
+		this.Latency.Merge(other.Latency)
+	}
+	for code, count := range other.Codes {
+		this.Codes[code] += count
+	}
+	this.Errors.Add(other.Errors)
+}
+
+//Group of -group flag, source file is loaded by caller
+type GroupSpec struct {
+	Name   string
+	Weight int
+	//Source file, empty for config URL
+	Source string
+}
+
+//Repeatable -group name:weight:source flag
+type GroupsFlag []GroupSpec
+
+func (this *GroupsFlag) String() string {
+	var result []string
+	for _, group := range *this {
+		result = append(result, fmt.Sprintf("%s:%d:%s", group.Name, group.Weight, group.Source))
+	}
+	return strings.Join(result, ", ")
+}
+
+func (this *GroupsFlag) Set(value string) error {
+	f := strings.SplitN(value, ":", 3)
+	if len(f) < 2 || strings.TrimSpace(f[0]) == "" {
+		return fmt.Errorf("group %q must be like name:weight:source", value)
+	}
+	weight, err := strconv.Atoi(strings.TrimSpace(f[1]))
+	if err != nil || weight <= 0 {
+		return fmt.Errorf("group %q weight must be positive", value)
+	}
+	group := GroupSpec{Name: strings.TrimSpace(f[0]), Weight: weight}
+	if len(f) == 3 {
+		group.Source = strings.TrimSpace(f[2])
+	}
+	*this = append(*this, group)
+	return nil
+}
+
+//Interleaved group indexes by smooth weighted round robin, every group is repeated weight/gcd times
+func newGroupSchedule(groups []*RequestGroup) ([]int, error) {
+	divisor := 0
+	names := map[string]bool{}
+	for _, group := range groups {
+		if group.Weight <= 0 {
+			return nil, fmt.Errorf("group %s weight must be positive", group.Name)
+		}
+		if names[group.Name] {
+			return nil, fmt.Errorf("group %s is duplicated", group.Name)
+		}
+		names[group.Name] = true
+		divisor = gcd(divisor, group.Weight)
+	}
+	total := 0
+	for _, group := range groups {
+		total += group.Weight / divisor
+	}
+	if total > maxGroupSchedule {
+		return nil, errors.New("groups weights are too precise, use percents")
+	}
+	current := make([]int, len(groups))
+	result := make([]int, 0, total)
+	for len(result) < total {
+		best := 0
+		for i, group := range groups {
+			current[i] += group.Weight / divisor
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		result = append(result, best)
+	}
+	return result, nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+//Group index of next request
+func (this *testRun) nextGroup() int {
+	seq := atomic.AddInt64(&this.groupSeq, 1) - 1
+	return this.groupSchedule[seq%int64(len(this.groupSchedule))]
+}
+
+//Print per group statistic and total
+func PrintGroups(w io.Writer, report *Report) {
+	source := report.Stats
+	if len(source.Groups) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Groups: \n     %v %v %v %v %v %v %v %v  %v\n",
+		newSpacesFormatRightf("Group", 16, "%s"),
+		newSpacesFormat("Requests", 10),
+		newSpacesFormat("Req/sec", 10),
+		newSpacesFormat("P50", 10),
+		newSpacesFormat("P99", 10),
+		newSpacesFormat("Max", 10),
+		newSpacesFormat("Errors", 8),
+		newSpacesFormat("Net In", 10),
+		"Codes",
+	)
+	row := func(name string, requests int, latency *Histogram, errors int, readed int64, codes map[int]int) {
+		fmt.Fprintf(w, "     %v %v %v %v %v %v %v %v  %v\n",
+			newSpacesFormatRightf(name, 16, "%s"),
+			newSpacesFormat(requests, 10),
+			newSpacesFormatf(perSecond(requests, source.Work), 10, "%.2f"),
+			newSpacesFormat(roundMicroDuration(latency.Percentile(50)), 10),
+			newSpacesFormat(roundMicroDuration(latency.Percentile(99)), 10),
+			newSpacesFormat(roundMicroDuration(latency.Max()), 10),
+			newSpacesFormat(errors, 8),
+			newSpacesFormat(Bytes(readed), 10),
+			codesString(codes),
+		)
+	}
+	for _, group := range source.Groups {
+		row(group.Name, group.Requests, group.Latency, group.Errors.Total(), group.Readed, group.Codes)
+	}
+	row("Total", source.Requests, source.Latency, source.Errors().Total(), source.Readed, source.Codes)
+	fmt.Fprintf(w, "     Groups share pipelined connections, group latency includes wait for responses of other groups sent before\n")
+}
+
+//Rate of count in work time, 0 for empty work time
+func perSecond(count int, work time.Duration) float64 {
+	if work <= 0 {
+		return 0
+	}
+	return float64(count) / work.Seconds()
+}
+
+//Codes like "200:95 503:5" sorted by code
+func codesString(codes map[int]int) string {
+	keys := make([]int, 0, len(codes))
+	for code := range codes {
+		keys = append(keys, code)
+	}
+	sort.Ints(keys)
+	result := make([]string, len(keys))
+	for i, code := range keys {
+		result[i] = fmt.Sprintf("%d:%d", code, codes[code])
+	}
+	return strings.Join(result, " ")
+}
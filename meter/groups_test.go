@@ -0,0 +1,112 @@
+package meter
+
+import (
+	nethttp "net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGroupsFlag(t *testing.T) {
+	var groups GroupsFlag
+	for _, value := range []string{"search:70:search.txt", " item : 20 ", "cart:10:data:1.txt"} {
+		if err := groups.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := GroupsFlag{{"search", 70, "search.txt"}, {"item", 20, ""}, {"cart", 10, "data:1.txt"}}
+	if len(groups) != len(expected) {
+		t.Fatalf("groups = %v", groups)
+	}
+	for i := range expected {
+		if groups[i] != expected[i] {
+			t.Errorf("group %d = %+v, want %+v", i, groups[i], expected[i])
+		}
+	}
+	if groups.String() != "search:70:search.txt, item:20:, cart:10:data:1.txt" {
+		t.Errorf("String = %q", groups.String())
+	}
+	for _, value := range []string{"", "search", ":10", "search:0", "search:-1:a.txt", "search:many"} {
+		if err := groups.Set(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
+
+func TestNewGroupSchedule(t *testing.T) {
+	groups := []*RequestGroup{{Name: "search", Weight: 70}, {Name: "item", Weight: 20}, {Name: "cart", Weight: 10}}
+	schedule, err := newGroupSchedule(groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Weights are divided by common divisor
+	if len(schedule) != 10 {
+		t.Fatalf("schedule = %v", schedule)
+	}
+	counts := make([]int, len(groups))
+	for _, group := range schedule {
+		counts[group]++
+	}
+	if counts[0] != 7 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+	//Groups are interleaved, heavy group is not sent in a row
+	for i := 0; i+3 < len(schedule); i++ {
+		if schedule[i] == 0 && schedule[i+1] == 0 && schedule[i+2] == 0 && schedule[i+3] == 0 {
+			t.Errorf("schedule is not interleaved %v", schedule)
+			break
+		}
+	}
+	run := &testRun{Config: &Config{Groups: groups}, groupSchedule: schedule}
+	counts = make([]int, len(groups))
+	for i := 0; i < 1000; i++ {
+		counts[run.nextGroup()]++
+	}
+	if counts[0] != 700 || counts[1] != 200 || counts[2] != 100 {
+		t.Errorf("next group counts = %v", counts)
+	}
+
+	for _, groups := range [][]*RequestGroup{
+		{{Name: "a", Weight: 0}},
+		{{Name: "a", Weight: 1}, {Name: "a", Weight: 2}},
+		{{Name: "a", Weight: 10007}, {Name: "b", Weight: 10009}},
+	} {
+		if _, err := newGroupSchedule(groups); err == nil {
+			t.Errorf("%v: expected error", groups)
+		}
+	}
+}
+
+func TestGroupsLatency(t *testing.T) {
+	u := startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(503)
+		}
+	})
+	group := func(name string, path string) *RequestGroup {
+		target, _ := url.Parse(u.String() + path)
+		return &RequestGroup{Name: name, Weight: 1, Source: &Source{Requests: []*SourceRequest{{URL: target}}}}
+	}
+	config := Config{
+		Url:         u,
+		Connections: 2,
+		Threads:     1,
+		Rate:        100,
+		Duration:    500 * time.Millisecond,
+		Groups:      []*RequestGroup{group("fast", "/fast"), group("slow", "/slow")},
+	}
+	//Requests of groups are pipelined, connections are not waiting slow responses and sends are not missed
+	stats := runTest(t, config, 5*time.Second).Stats
+	fast, slow := stats.Groups[0], stats.Groups[1]
+	if stats.MissedRequests != 0 || fast.Requests+slow.Requests != stats.Requests || stats.Requests < 45 {
+		t.Fatalf("fast %d, slow %d of %d requests, missed %d", fast.Requests, slow.Requests, stats.Requests, stats.MissedRequests)
+	}
+	//Responses are counted to group of queued request
+	if len(fast.Codes) != 1 || fast.Codes[200] != fast.Requests || len(slow.Codes) != 1 || slow.Codes[503] != slow.Requests {
+		t.Errorf("fast codes %v, slow codes %v", fast.Codes, slow.Codes)
+	}
+	if p50 := slow.Latency.Percentile(50); p50 < 50*time.Millisecond {
+		t.Errorf("slow group p50 %v", p50)
+	}
+}
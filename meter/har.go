@@ -0,0 +1,166 @@
+package meter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+//HAR entries filter
+type HARFilter struct {
+	//Allowed hosts, empty for any
+	Hosts []string
+	//Path regexp, nil for any
+	Path *regexp.Regexp
+	//Allowed methods, empty for any
+	Methods      []string
+	StripCookies bool
+}
+
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request harRequest `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harRequest struct {
+	Method  string `json:"method"`
+	URL     string `json:"url"`
+	Headers []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"headers"`
+	PostData *struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Encoding string `json:"encoding"`
+		Params   []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"params"`
+	} `json:"postData"`
+}
+
+//Headers are set by go-meter or not valid for HTTP/1.1
+var harSkipHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+//Check source file is HAR by extension
+func IsHARSource(fileName string) bool {
+	return strings.HasSuffix(fileName, ".har")
+}
+
+//Load HAR file entries as structured source
+func LoadHARSource(fileName string, filter *HARFilter) (*Source, error) {
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	har := harFile{}
+	if err = json.Unmarshal(file, &har); err != nil {
+		return nil, err
+	}
+	newThis := Source{File: fileName}
+	for _, entry := range har.Log.Entries {
+		request, err := entry.Request.toRequest()
+		if err != nil {
+			return nil, err
+		}
+		if !filter.Match(request) {
+			continue
+		}
+		if filter.StripCookies {
+			delete(request.Header, "Cookie")
+		}
+		newThis.Requests = append(newThis.Requests, request)
+	}
+	if len(newThis.Requests) == 0 {
+		return nil, errors.New("no HAR entries match filter")
+	}
+	return &newThis, nil
+}
+
+func (this *harRequest) toRequest() (*SourceRequest, error) {
+	u, err := url.Parse(this.URL)
+	if err != nil {
+		return nil, err
+	}
+	result := &SourceRequest{
+		Method: strings.ToUpper(this.Method),
+		URL:    u,
+		Header: map[string][]string{},
+		RawURI: this.URL,
+	}
+	for _, header := range this.Headers {
+		//HTTP/2 pseudo headers
+		if strings.HasPrefix(header.Name, ":") {
+			continue
+		}
+		key := textproto.CanonicalMIMEHeaderKey(header.Name)
+		if harSkipHeaders[key] {
+			continue
+		}
+		result.Header[key] = append(result.Header[key], header.Value)
+	}
+	if data := this.PostData; data != nil {
+		switch {
+		case data.Encoding == "base64":
+			if result.Body, err = base64.StdEncoding.DecodeString(data.Text); err != nil {
+				return nil, err
+			}
+		case data.Text == "" && len(data.Params) > 0:
+			values := url.Values{}
+			for _, param := range data.Params {
+				values.Add(param.Name, param.Value)
+			}
+			result.Body = []byte(values.Encode())
+		default:
+			result.Body = []byte(data.Text)
+		}
+	}
+	return result, nil
+}
+
+func (this *HARFilter) Match(request *SourceRequest) bool {
+	if len(this.Hosts) > 0 && !containsFold(this.Hosts, request.URL.Host) {
+		return false
+	}
+	if len(this.Methods) > 0 && !containsFold(this.Methods, request.Method) {
+		return false
+	}
+	if this.Path != nil && !this.Path.MatchString(request.URL.Path) {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+//Split comma separated list, empty items are skipped
+func SplitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
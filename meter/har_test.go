@@ -0,0 +1,124 @@
+package meter
+
+import (
+	"regexp"
+	"testing"
+)
+
+const testHAR = `{"log": {"entries": [
+	{"request": {"method": "GET", "url": "https://api.example.com/v1/items?page=2",
+		"headers": [{"name": ":authority", "value": "api.example.com"}, {"name": "Host", "value": "api.example.com"},
+			{"name": "cookie", "value": "session=1"}, {"name": "Accept", "value": "application/json"},
+			{"name": "Connection", "value": "keep-alive"}]}},
+	{"request": {"method": "post", "url": "https://api.example.com/v1/login",
+		"headers": [{"name": "Content-Length", "value": "13"}, {"name": "Cookie", "value": "session=1"}],
+		"postData": {"mimeType": "application/json", "text": "{\"user\":\"a\"}"}}},
+	{"request": {"method": "POST", "url": "https://api.example.com/v1/form",
+		"postData": {"mimeType": "application/x-www-form-urlencoded", "params": [{"name": "a", "value": "1 2"}, {"name": "b", "value": "x"}]}}},
+	{"request": {"method": "PUT", "url": "https://cdn.example.com/static/logo.png",
+		"postData": {"mimeType": "image/png", "text": "AAEC", "encoding": "base64"}}}
+]}}`
+
+func TestLoadHARSource(t *testing.T) {
+	fileName := writeTestFile(t, "session.har", testHAR)
+	source, err := LoadHARSource(fileName, &HARFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.File != fileName || len(source.Requests) != 4 {
+		t.Fatalf("file %q, requests %d", source.File, len(source.Requests))
+	}
+	get := source.Requests[0]
+	if get.Method != "GET" || get.URL.String() != "https://api.example.com/v1/items?page=2" || get.RawURI != get.URL.String() {
+		t.Errorf("get request %s %s %q", get.Method, get.URL, get.RawURI)
+	}
+	if len(get.Header) != 2 || get.Header["Cookie"][0] != "session=1" || get.Header["Accept"][0] != "application/json" {
+		t.Errorf("get headers %v, pseudo, Host and Connection headers must be skipped", get.Header)
+	}
+	login := source.Requests[1]
+	if login.Method != "POST" || string(login.Body) != `{"user":"a"}` || len(login.Header) != 1 {
+		t.Errorf("login request %s %q %v", login.Method, login.Body, login.Header)
+	}
+	if form := source.Requests[2]; string(form.Body) != "a=1+2&b=x" {
+		t.Errorf("form body %q", form.Body)
+	}
+	if upload := source.Requests[3]; string(upload.Body) != "\x00\x01\x02" {
+		t.Errorf("base64 body %q", upload.Body)
+	}
+}
+
+func TestLoadHARSourceFilter(t *testing.T) {
+	fileName := writeTestFile(t, "session.har", testHAR)
+	tests := []struct {
+		name   string
+		filter HARFilter
+		urls   []string
+	}{
+		{
+			name:   "host",
+			filter: HARFilter{Hosts: []string{"CDN.example.com"}},
+			urls:   []string{"https://cdn.example.com/static/logo.png"},
+		},
+		{
+			name:   "methods",
+			filter: HARFilter{Methods: []string{"post", "put"}},
+			urls:   []string{"https://api.example.com/v1/login", "https://api.example.com/v1/form", "https://cdn.example.com/static/logo.png"},
+		},
+		{
+			name:   "path",
+			filter: HARFilter{Path: regexp.MustCompile(`^/v1/(items|form)$`)},
+			urls:   []string{"https://api.example.com/v1/items?page=2", "https://api.example.com/v1/form"},
+		},
+		{
+			name:   "all filters",
+			filter: HARFilter{Hosts: []string{"api.example.com"}, Methods: []string{"POST"}, Path: regexp.MustCompile(`login`)},
+			urls:   []string{"https://api.example.com/v1/login"},
+		},
+	}
+	for _, test := range tests {
+		source, err := LoadHARSource(fileName, &test.filter)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		var urls []string
+		for _, request := range source.Requests {
+			urls = append(urls, request.URL.String())
+		}
+		if len(urls) != len(test.urls) {
+			t.Errorf("%s: urls %v, want %v", test.name, urls, test.urls)
+			continue
+		}
+		for i := range urls {
+			if urls[i] != test.urls[i] {
+				t.Errorf("%s: urls %v, want %v", test.name, urls, test.urls)
+				break
+			}
+		}
+	}
+	source, err := LoadHARSource(fileName, &HARFilter{StripCookies: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range source.Requests {
+		if _, ok := request.Header["Cookie"]; ok {
+			t.Errorf("%s: cookie is not stripped", request.URL)
+		}
+	}
+	if _, err = LoadHARSource(fileName, &HARFilter{Hosts: []string{"other"}}); err == nil {
+		t.Error("no matched entries: expected error")
+	}
+	if _, err = LoadHARSource(writeTestFile(t, "broken.har", `{"log": {"entries": [`), &HARFilter{}); err == nil {
+		t.Error("broken HAR: expected error")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	result := SplitList(" a, b ,,c,")
+	if len(result) != 3 || result[0] != "a" || result[1] != "b" || result[2] != "c" {
+		t.Errorf("SplitList = %q", result)
+	}
+	if len(SplitList("")) != 0 {
+		t.Error("empty list")
+	}
+}
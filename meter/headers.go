@@ -0,0 +1,118 @@
+package meter
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+)
+
+//Repeatable -H "Name: value" flag
+type HeadersFlag map[string][]string
+
+func (this HeadersFlag) String() string {
+	var lines []string
+	for key, values := range this {
+		for _, value := range values {
+			lines = append(lines, key+": "+value)
+		}
+	}
+	return strings.Join(lines, ", ")
+}
+
+func (this HeadersFlag) Set(line string) error {
+	key, value, err := ParseHeader(line)
+	if err != nil {
+		return err
+	}
+	this[key] = append(this[key], value)
+	return nil
+}
+
+//Parse "Name: value" line, name is canonicalized
+func ParseHeader(line string) (string, string, error) {
+	f := strings.SplitN(line, ":", 2)
+	if len(f) != 2 || len(strings.TrimSpace(f[0])) == 0 {
+		return "", "", fmt.Errorf("header %q must be like \"Name: value\"", line)
+	}
+	key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(f[0]))
+	if key == "Content-Length" {
+		return "", "", errors.New("Content-Length header is computed from request body")
+	}
+	return key, strings.TrimSpace(f[1]), nil
+}
+
+//Load headers file with "Name: value" lines, empty lines and lines started with # are skipped
+func LoadHeaders(fileName string, headers map[string][]string) error {
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(file), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, err := ParseHeader(line)
+		if err != nil {
+			return err
+		}
+		headers[key] = append(headers[key], value)
+	}
+	return nil
+}
+
+//Copy headers, request headers can be changed on send
+func copyHeaders(headers map[string][]string) map[string][]string {
+	result := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		result[key] = values
+	}
+	return result
+}
+
+//Value of secret headers in reports
+const redactedValue = "REDACTED"
+
+//Headers with credentials, names with these words are secret too
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+var secretHeaderWords = []string{"token", "secret", "password", "key", "session", "auth"}
+
+//Is header value a credential
+func isSecretHeader(key string) bool {
+	if secretHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+//Copy headers with secret values replaced, headers can be written to reports
+func redactHeaders(headers map[string][]string) map[string][]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	result := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		if isSecretHeader(key) {
+			redacted := make([]string, len(values))
+			for i := range redacted {
+				redacted[i] = redactedValue
+			}
+			values = redacted
+		}
+		result[key] = values
+	}
+	return result
+}
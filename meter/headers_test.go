@@ -0,0 +1,116 @@
+package meter
+
+import (
+	nethttp "net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		line  string
+		key   string
+		value string
+		err   string
+	}{
+		{"Accept: */*", "Accept", "*/*", ""},
+		{"  x-request-id :  42  ", "X-Request-Id", "42", ""},
+		{"Authorization: Bearer a:b", "Authorization", "Bearer a:b", ""},
+		{"X-Empty:", "X-Empty", "", ""},
+		{"Accept", "", "", "must be like"},
+		{": value", "", "", "must be like"},
+		{"", "", "", "must be like"},
+		{"content-length: 10", "", "", "computed from request body"},
+	}
+	for _, test := range tests {
+		key, value, err := ParseHeader(test.line)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: error %v, want %q", test.line, err, test.err)
+			}
+			continue
+		}
+		if err != nil || key != test.key || value != test.value {
+			t.Errorf("%q: %q, %q, %v", test.line, key, value, err)
+		}
+	}
+}
+
+func TestLoadHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		result map[string][]string
+		err    string
+	}{
+		{"comments and blank lines", "# auth\n\nAuthorization: Bearer x\n  \n  # accept\naccept: */*\r\n",
+			map[string][]string{"Authorization": {"Bearer x"}, "Accept": {"*/*"}}, ""},
+		{"repeated header", "X-Tag: a\nX-Tag: b\n", map[string][]string{"X-Tag": {"a", "b"}}, ""},
+		{"empty file", "", map[string][]string{}, ""},
+		{"malformed line", "Accept: */*\nnot a header\n", nil, "not a header"},
+		{"content length", "Content-Length: 5\n", nil, "Content-Length"},
+	}
+	for _, test := range tests {
+		result := map[string][]string{}
+		err := LoadHeaders(writeTestFile(t, "headers.txt", test.data), result)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(result, test.result) {
+			t.Errorf("%s: %v, %v", test.name, result, err)
+		}
+	}
+	if err := LoadHeaders("not-exists.txt", map[string][]string{}); err == nil {
+		t.Error("missing file: expected error")
+	}
+}
+
+func TestHeadersFlag(t *testing.T) {
+	headers := HeadersFlag{}
+	for _, line := range []string{"x-tag: a", "X-Tag: b", "Accept: */*"} {
+		if err := headers.Set(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(map[string][]string(headers), map[string][]string{"X-Tag": {"a", "b"}, "Accept": {"*/*"}}) {
+		t.Errorf("headers %v", headers)
+	}
+	if err := headers.Set("bad"); err == nil {
+		t.Error("malformed header: expected error")
+	}
+}
+
+func TestHostHeader(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		hosts []string
+	)
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			lock.Lock()
+			hosts = append(hosts, r.Host)
+			lock.Unlock()
+		}),
+		Header:      map[string][]string{"Host": {"service.test"}, "Accept": {"*/*"}},
+		Connections: 1,
+		Threads:     1,
+		MRQ:         -1,
+		Requests:    3,
+	}
+	//Duplicate Host header is rejected by server with 400
+	stats := runTest(t, config, 5*time.Second).Stats
+	if stats.Codes[200] != 3 {
+		t.Errorf("codes %v", stats.Codes)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual(hosts, []string{"service.test", "service.test", "service.test"}) {
+		t.Errorf("hosts %v", hosts)
+	}
+}
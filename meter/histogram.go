@@ -0,0 +1,265 @@
+package meter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+//Default significant decimal digits of histogram values
+const DefaultHistogramPrecision = 3
+
+//High dynamic range histogram of durations in nanoseconds.
+//Values are stored in log2 buckets split to linear sub buckets,
+//so relative error of any value is less than 10^-precision
+type Histogram struct {
+	precision     int
+	subBucketBits uint
+	subBucketHalf int64
+	subBucketMask int64
+	counts        []int64
+	total         int64
+	sum           int64
+	min           int64
+	max           int64
+}
+
+//Create histogram with precision 1..5 significant decimal digits
+func NewHistogram(precision int) *Histogram {
+	if precision < 1 {
+		precision = 1
+	} else if precision > 5 {
+		precision = 5
+	}
+	largest := 2 * int64(math.Pow10(precision))
+	subBucketBits := uint(bits.Len64(uint64(largest - 1)))
+	subBucketCount := int64(1) << subBucketBits
+	return &Histogram{
+		precision:     precision,
+		subBucketBits: subBucketBits,
+		subBucketHalf: subBucketCount / 2,
+		subBucketMask: subBucketCount - 1,
+		counts:        make([]int64, subBucketCount),
+	}
+}
+
+func (this *Histogram) Precision() int {
+	return this.precision
+}
+
+func (this *Histogram) Record(d time.Duration) {
+	this.RecordValues(d, 1)
+}
+
+func (this *Histogram) RecordValues(d time.Duration, count int64) {
+	if count <= 0 {
+		return
+	}
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	index := this.countsIndex(v)
+	if index >= len(this.counts) {
+		counts := make([]int64, index+int(this.subBucketHalf))
+		copy(counts, this.counts)
+		this.counts = counts
+	}
+	this.counts[index] += count
+	if this.total == 0 || v < this.min {
+		this.min = v
+	}
+	if v > this.max {
+		this.max = v
+	}
+	this.total += count
+	this.sum += v * count
+}
+
+//Add all values of other histogram
+func (this *Histogram) Merge(other *Histogram) {
+	if other.total == 0 {
+		return
+	}
+	total, sum, min, max := this.total, this.sum, this.min, this.max
+	if other.precision == this.precision {
+		if len(other.counts) > len(this.counts) {
+			counts := make([]int64, len(other.counts))
+			copy(counts, this.counts)
+			this.counts = counts
+		}
+		for index, count := range other.counts {
+			this.counts[index] += count
+		}
+	} else {
+		for index, count := range other.counts {
+			if count > 0 {
+				this.RecordValues(time.Duration(other.valueFromIndex(index)), count)
+			}
+		}
+	}
+	//Keep exact limits and sum of merged values
+	this.total = total + other.total
+	this.sum = sum + other.sum
+	this.min, this.max = other.min, other.max
+	if total > 0 && min < this.min {
+		this.min = min
+	}
+	if max > this.max {
+		this.max = max
+	}
+}
+
+func (this *Histogram) Reset() {
+	for i := range this.counts {
+		this.counts[i] = 0
+	}
+	this.total, this.sum, this.min, this.max = 0, 0, 0, 0
+}
+
+func (this *Histogram) Count() int64 {
+	return this.total
+}
+
+func (this *Histogram) Min() time.Duration {
+	return time.Duration(this.min)
+}
+
+func (this *Histogram) Max() time.Duration {
+	return time.Duration(this.max)
+}
+
+func (this *Histogram) Mean() time.Duration {
+	if this.total == 0 {
+		return 0
+	}
+	return time.Duration(this.sum / this.total)
+}
+
+//Sum of all values
+func (this *Histogram) Sum() time.Duration {
+	return time.Duration(this.sum)
+}
+
+//Count of values less or equal d, values are compared with histogram precision
+func (this *Histogram) CountAtOrBelow(d time.Duration) int64 {
+	if int64(d) >= this.max {
+		return this.total
+	}
+	var result int64
+	for index, count := range this.counts {
+		if this.valueFromIndex(index) > int64(d) {
+			break
+		}
+		result += count
+	}
+	return result
+}
+
+//Value at percentile 0..100
+func (this *Histogram) Percentile(percentile float64) time.Duration {
+	if this.total == 0 {
+		return 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	target := int64(math.Ceil(percentile / 100 * float64(this.total)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for index, count := range this.counts {
+		seen += count
+		if seen >= target {
+			value := this.highestEquivalentValue(this.valueFromIndex(index))
+			if value > this.max {
+				value = this.max
+			}
+			return time.Duration(value)
+		}
+	}
+	return time.Duration(this.max)
+}
+
+//Serialized histogram, counts are [index, count] pairs of not empty sub buckets
+type histogramJSON struct {
+	Precision int        `json:"precision"`
+	Counts    [][2]int64 `json:"counts"`
+	Total     int64      `json:"total"`
+	Sum       int64      `json:"sum"`
+	Min       int64      `json:"min"`
+	Max       int64      `json:"max"`
+}
+
+func (this *Histogram) MarshalJSON() ([]byte, error) {
+	result := histogramJSON{
+		Precision: this.precision,
+		Counts:    [][2]int64{},
+		Total:     this.total,
+		Sum:       this.sum,
+		Min:       this.min,
+		Max:       this.max,
+	}
+	for index, count := range this.counts {
+		if count > 0 {
+			result.Counts = append(result.Counts, [2]int64{int64(index), count})
+		}
+	}
+	return json.Marshal(result)
+}
+
+func (this *Histogram) UnmarshalJSON(data []byte) error {
+	value := histogramJSON{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	//Histogram is received from agents, broken values must not panic
+	if value.Precision < 1 || value.Precision > 5 {
+		return fmt.Errorf("histogram precision %d is out of range", value.Precision)
+	}
+	*this = *NewHistogram(value.Precision)
+	maxIndex := this.countsIndex(math.MaxInt64)
+	var total int64
+	for _, item := range value.Counts {
+		index, count := item[0], item[1]
+		if index < 0 || index > int64(maxIndex) || count <= 0 || total+count < total {
+			return fmt.Errorf("histogram count %d of index %d is broken", count, index)
+		}
+		if int(index) >= len(this.counts) {
+			counts := make([]int64, int(index)+int(this.subBucketHalf))
+			copy(counts, this.counts)
+			this.counts = counts
+		}
+		this.counts[index] += count
+		total += count
+	}
+	if total != value.Total || value.Min < 0 || value.Max < value.Min {
+		return fmt.Errorf("histogram total %d, min %d, max %d do not match counts", value.Total, value.Min, value.Max)
+	}
+	this.total, this.sum, this.min, this.max = value.Total, value.Sum, value.Min, value.Max
+	return nil
+}
+
+func (this *Histogram) countsIndex(v int64) int {
+	bucket := int(bits.Len64(uint64(v|this.subBucketMask))) - int(this.subBucketBits)
+	subBucket := v >> uint(bucket)
+	return int((int64(bucket+1) << (this.subBucketBits - 1)) + subBucket - this.subBucketHalf)
+}
+
+func (this *Histogram) valueFromIndex(index int) int64 {
+	bucket := (index >> (this.subBucketBits - 1)) - 1
+	subBucket := int64(index)&(this.subBucketHalf-1) + this.subBucketHalf
+	if bucket < 0 {
+		subBucket -= this.subBucketHalf
+		bucket = 0
+	}
+	return subBucket << uint(bucket)
+}
+
+func (this *Histogram) highestEquivalentValue(v int64) int64 {
+	bucket := int(bits.Len64(uint64(v|this.subBucketMask))) - int(this.subBucketBits)
+	return v + (int64(1) << uint(bucket)) - 1
+}
@@ -0,0 +1,159 @@
+package meter
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+//Relative difference of histogram value from exact value
+func relativeError(value, exact time.Duration) float64 {
+	return math.Abs(float64(value-exact)) / float64(exact)
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	for precision := 1; precision <= 5; precision++ {
+		h := NewHistogram(precision)
+		//1µs..100ms uniform
+		for i := 1; i <= 100000; i++ {
+			h.Record(time.Duration(i) * time.Microsecond)
+		}
+		if h.Count() != 100000 {
+			t.Fatalf("precision %d: count = %d", precision, h.Count())
+		}
+		if h.Min() != time.Microsecond || h.Max() != 100*time.Millisecond {
+			t.Errorf("precision %d: min = %v, max = %v", precision, h.Min(), h.Max())
+		}
+		if h.Mean() != time.Duration(100001)*time.Microsecond/2 {
+			t.Errorf("precision %d: mean = %v", precision, h.Mean())
+		}
+		limit := math.Pow10(-precision)
+		for _, percentile := range []float64{1, 10, 50, 75, 90, 99, 99.9, 99.99} {
+			//Value of percentile rank, rank is rounded like in Percentile
+			exact := time.Duration(math.Ceil(percentile/100*100000)) * time.Microsecond
+			value := h.Percentile(percentile)
+			if e := relativeError(value, exact); e > limit {
+				t.Errorf("precision %d: p%g = %v, want %v, error %g > %g", precision, percentile, value, exact, e, limit)
+			}
+		}
+		if h.Percentile(100) != h.Max() || h.Percentile(200) != h.Max() {
+			t.Errorf("precision %d: p100 = %v, want max", precision, h.Percentile(100))
+		}
+	}
+}
+
+func TestHistogramLimits(t *testing.T) {
+	h := NewHistogram(DefaultHistogramPrecision)
+	if h.Percentile(50) != 0 || h.Mean() != 0 {
+		t.Error("empty histogram must return 0")
+	}
+	h.Record(-time.Second)
+	h.Record(time.Hour)
+	h.RecordValues(time.Millisecond, 0)
+	if h.Count() != 2 || h.Min() != 0 || h.Max() != time.Hour {
+		t.Errorf("count = %d, min = %v, max = %v", h.Count(), h.Min(), h.Max())
+	}
+	if e := relativeError(h.Percentile(100), time.Hour); e > 0.001 {
+		t.Errorf("p100 = %v", h.Percentile(100))
+	}
+	if got := h.CountAtOrBelow(time.Minute); got != 1 {
+		t.Errorf("CountAtOrBelow(1m) = %d", got)
+	}
+	h.Reset()
+	if h.Count() != 0 || h.Max() != 0 || h.Percentile(99) != 0 {
+		t.Error("reset histogram is not empty")
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	for _, precision := range []int{DefaultHistogramPrecision, 2} {
+		a := NewHistogram(DefaultHistogramPrecision)
+		b := NewHistogram(precision)
+		all := NewHistogram(DefaultHistogramPrecision)
+		for i := 1; i <= 1000; i++ {
+			a.Record(time.Duration(i) * time.Millisecond)
+			all.Record(time.Duration(i) * time.Millisecond)
+		}
+		for i := 1; i <= 500; i++ {
+			b.RecordValues(time.Duration(i)*time.Microsecond, 2)
+			all.RecordValues(time.Duration(i)*time.Microsecond, 2)
+		}
+		//Merge of empty histogram changes nothing
+		a.Merge(NewHistogram(DefaultHistogramPrecision))
+		a.Merge(b)
+		if a.Count() != all.Count() || a.Sum() != all.Sum() || a.Min() != all.Min() || a.Max() != all.Max() {
+			t.Errorf("precision %d: merged count %d sum %v min %v max %v, want %d %v %v %v",
+				precision, a.Count(), a.Sum(), a.Min(), a.Max(), all.Count(), all.Sum(), all.Min(), all.Max())
+		}
+		limit := math.Pow10(-precision)
+		for _, percentile := range []float64{10, 50, 90, 99} {
+			if e := relativeError(a.Percentile(percentile), all.Percentile(percentile)); e > limit {
+				t.Errorf("precision %d: merged p%g = %v, want %v", precision, percentile, a.Percentile(percentile), all.Percentile(percentile))
+			}
+		}
+	}
+	//Merge to empty histogram keeps other limits
+	empty := NewHistogram(DefaultHistogramPrecision)
+	other := NewHistogram(DefaultHistogramPrecision)
+	other.Record(time.Second)
+	empty.Merge(other)
+	if empty.Min() != time.Second || empty.Max() != time.Second || empty.Count() != 1 {
+		t.Errorf("min = %v, max = %v, count = %d", empty.Min(), empty.Max(), empty.Count())
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := NewHistogram(4)
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i*i) * time.Microsecond)
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &Histogram{}
+	if err = json.Unmarshal(data, result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Precision() != 4 || result.Count() != h.Count() || result.Sum() != h.Sum() || result.Min() != h.Min() || result.Max() != h.Max() {
+		t.Errorf("round trip precision %d count %d sum %v min %v max %v", result.Precision(), result.Count(), result.Sum(), result.Min(), result.Max())
+	}
+	for _, percentile := range reportPercentiles {
+		if result.Percentile(percentile) != h.Percentile(percentile) {
+			t.Errorf("round trip p%g = %v, want %v", percentile, result.Percentile(percentile), h.Percentile(percentile))
+		}
+	}
+	//Empty histogram
+	data, err = json.Marshal(NewHistogram(DefaultHistogramPrecision))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, result); err != nil || result.Count() != 0 || result.Precision() != DefaultHistogramPrecision {
+		t.Errorf("empty round trip: %v, count %d", err, result.Count())
+	}
+	//Histograms of agents are checked, broken values are errors
+	for _, data := range []string{
+		`{"precision":3,"counts":"broken"}`,
+		`{"precision":0,"counts":[]}`,
+		`{"precision":6,"counts":[]}`,
+		`{"precision":3,"counts":[[-1,1]],"total":1}`,
+		`{"precision":3,"counts":[[9223372036854775807,1]],"total":1}`,
+		`{"precision":3,"counts":[[100000000,1]],"total":1}`,
+		`{"precision":3,"counts":[[1,-5]],"total":-5}`,
+		`{"precision":3,"counts":[[1,9223372036854775807],[2,9223372036854775807]],"total":1}`,
+		`{"precision":3,"counts":[[1,2]],"total":3,"min":1,"max":1}`,
+		`{"precision":3,"counts":[[1,1]],"total":1,"min":5,"max":1}`,
+	} {
+		if err = json.Unmarshal([]byte(data), result); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+	//Largest value index is valid
+	max := NewHistogram(DefaultHistogramPrecision)
+	max.Record(time.Duration(math.MaxInt64))
+	data, _ = json.Marshal(max)
+	if err = json.Unmarshal(data, result); err != nil || result.Max() != max.Max() {
+		t.Errorf("max value: %v, max %v", err, result.Max())
+	}
+}
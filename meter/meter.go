@@ -0,0 +1,337 @@
+//Package meter runs HTTP benchmarks, go-meter command is a thin wrapper around Run
+package meter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Default wait for in flight responses after load is stopped
+const DefaultDrainTimeout = time.Duration(5) * time.Second
+
+type RequestStats struct {
+	//Index of request group, 0 without groups
+	Group int
+	//Index of stage at send time, 0 without stages
+	Stage        int
+	ResponseCode int
+	Duration     time.Duration
+	//Request phases: write, wait for first byte, read headers, read body
+	Write             time.Duration
+	Wait              time.Duration
+	Headers           time.Duration
+	Body              time.Duration
+	ConnectDuration   time.Duration
+	HandshakeDuration time.Duration
+	NetIn             int64
+	NetOut            int64
+}
+
+//Test settings, zero values are defaults
+type Config struct {
+	//Default is GET
+	Method string
+	Url    *url.URL
+	//URL as written by user, used for templates and agents. Default is Url
+	RawURL string
+	//Host header overrides request host
+	Header map[string][]string
+	//Values of {{var COLUMN}}
+	Vars *TemplateVars
+	//Enable {{placeholders}} in URL, headers and body
+	Template bool
+	//TLS settings of https URL
+	TLS         *TLSOptions
+	Connections int
+	Threads     int
+	//Max requests per second, 0 or -1 for unlimited
+	MRQ int
+	//Constant arrival rate per second, overrides MRQ
+	Rate int
+	//Stop after total requests count and max requests per connection
+	Requests      int
+	PerConnection int
+	Stages        Stages
+	Reconnect     bool
+	//Timeouts, 0 for unlimited
+	ConnectTimeout   time.Duration
+	WriteTimeout     time.Duration
+	FirstByteTimeout time.Duration
+	ResponseTimeout  time.Duration
+	//Wait for in flight responses after load is stopped, default is DefaultDrainTimeout, negative to not wait
+	DrainTimeout time.Duration
+	//Print per second stats to Log
+	Verbose bool
+	//Latency histogram significant digits, default is DefaultHistogramPrecision
+	Precision      int
+	ExcludeSeconds time.Duration
+	//Request bodies or URLs, nil for Url only
+	Source *Source
+	//Weighted request groups with per group stats, Source is not used with groups
+	Groups []*RequestGroup
+	//Test duration, 0 for unlimited, stages override it
+	Duration time.Duration
+	//Progress output, nil to discard
+	Log        io.Writer
+	CSV        *CSVWriter
+	Thresholds []*Threshold
+	//Called by stats aggregator every second
+	OnSecond func(second time.Duration, stats *StatsSourcePerSecond)
+	//Print stats of running test to Log on receive, not supported with agents
+	Snapshot <-chan bool
+	//Clear stats and error counters of running test on receive, live metrics are not cleared
+	Reset <-chan bool
+	//Controller mode agents addresses
+	Agents []string
+	//Token of agents started with -agent-token
+	AgentToken string
+	//TLS of agents link, CA of agents certificates. Required for non-loopback agents, nil for plain link
+	AgentTLS *TLSOptions
+	//Live metrics, nil if disabled
+	Metrics *Metrics
+}
+
+//Runtime state of test run, settings are read from prepared config
+type testRun struct {
+	*Config
+	host         string
+	tlsConfig    *tls.Config
+	started      time.Time
+	manager      *connectionManager
+	stats        *StatsSource
+	counters     counters
+	workerQuit   chan bool
+	workerQuited chan bool
+	statsQuit    chan bool
+	statsQuited  chan bool
+	requestStats chan *RequestStats
+	//Group index of every request by weights, errors of groups
+	groupSchedule []int
+	groupSeq      int64
+	groupCounters []counters
+	//Errors of stages by send time
+	stageCounters []counters
+}
+
+//Result of test
+type Report struct {
+	//Test settings with defaults
+	Config     *Config
+	Stats      *StatsSource
+	Thresholds []*ThresholdResult
+	//Test was stopped by context
+	Interrupted bool
+}
+
+//Run test until duration, request budget or context cancel.
+//Config is not changed, so it can be run again.
+//In controller mode agents errors are returned with merged stats of completed agents,
+//Report.Stats is nil if test was not started
+func Run(ctx context.Context, config Config) (Report, error) {
+	run, err := config.prepare()
+	if err != nil {
+		return Report{}, err
+	}
+	if len(config.Agents) > 0 {
+		err = runController(ctx, run)
+		if run.started.IsZero() {
+			return Report{}, err
+		}
+		return run.report(ctx.Err() != nil), err
+	}
+	if err = run.connect(); err != nil {
+		return Report{}, err
+	}
+	defer run.manager.Close()
+	completed := runLoad(ctx, run, config.Duration)
+	return run.report(!completed), nil
+}
+
+//Check settings, set defaults and create runtime state of test run
+func (this *Config) prepare() (*testRun, error) {
+	if this.Url == nil {
+		return nil, errors.New("URL is required")
+	}
+	if this.Connections <= 0 || this.Threads <= 0 {
+		return nil, errors.New("connections and threads must be positive")
+	}
+	if this.Method == "" {
+		this.Method = "GET"
+	}
+	this.Method = strings.ToUpper(this.Method)
+	if this.RawURL == "" {
+		this.RawURL = this.Url.String()
+	}
+	if this.Header == nil {
+		this.Header = map[string][]string{}
+	}
+	if this.MRQ == 0 {
+		this.MRQ = -1
+	}
+	if this.DrainTimeout == 0 {
+		this.DrainTimeout = DefaultDrainTimeout
+	}
+	if this.Precision == 0 {
+		this.Precision = DefaultHistogramPrecision
+	}
+	run := &testRun{Config: this}
+	if len(this.Stages) > 0 {
+		this.Duration = this.Stages.Duration()
+		//Pool has connections of largest stage, not active connections are not dialed
+		this.Connections = this.Stages.MaxConnections(this.Connections)
+		run.stageCounters = make([]counters, len(this.Stages))
+	}
+	//Sources are compiled and iterated by test, caller sources are not changed
+	this.Source = this.Source.copy()
+	if this.Log == nil {
+		this.Log = ioutil.Discard
+	}
+	if this.TLS == nil {
+		this.TLS = &TLSOptions{}
+	}
+	if err := run.configureHost(); err != nil {
+		return nil, err
+	}
+	sources := []*Source{this.Source}
+	if len(this.Groups) > 0 {
+		if len(this.Source.Data) > 0 || len(this.Source.Requests) > 0 {
+			return nil, errors.New("source can not be used with groups")
+		}
+		var err error
+		if run.groupSchedule, err = newGroupSchedule(this.Groups); err != nil {
+			return nil, err
+		}
+		run.groupCounters = make([]counters, len(this.Groups))
+		sources = nil
+		groups := make([]*RequestGroup, len(this.Groups))
+		for i, group := range this.Groups {
+			groups[i] = &RequestGroup{Name: group.Name, Weight: group.Weight, Source: group.Source.copy()}
+			sources = append(sources, groups[i].Source)
+		}
+		this.Groups = groups
+	}
+	for _, source := range sources {
+		if this.Template {
+			if err := source.CompileTemplates(this, this.RawURL, this.Vars); err != nil {
+				return nil, err
+			}
+		} else if err := source.checkURLs(this.Method); err != nil {
+			return nil, err
+		}
+	}
+	run.workerQuit = make(chan bool, this.Threads)
+	run.workerQuited = make(chan bool, this.Threads)
+	run.statsQuit = make(chan bool, 1)
+	run.statsQuited = make(chan bool, 1)
+	run.requestStats = make(chan *RequestStats, this.Connections*512)
+	run.resetStats()
+	return run, nil
+}
+
+//Set request host and TLS config from URL, Host header overrides request host
+func (this *testRun) configureHost() error {
+	this.host = this.Url.Host
+	if strings.Index(this.host, ":") > -1 {
+		h := strings.SplitN(this.host, ":", 2)
+		this.host = h[0]
+	}
+
+	if this.Url.Scheme == "https" {
+		var err error
+		this.tlsConfig, err = NewTLSConfig(this.TLS, this.host)
+		if err != nil {
+			return fmt.Errorf("Can not configure TLS %v", err)
+		}
+	}
+
+	//Host header override, TLS server name is still URL host
+	if host := this.Header["Host"]; len(host) > 0 {
+		this.host = host[0]
+	}
+	return nil
+}
+
+//Open connections, error if no connection is established
+func (this *testRun) connect() error {
+	this.manager = newConnectionManager(this)
+	//check any connect, connected connections are returned to pool
+	if len(this.manager.C) == 0 {
+		this.manager.Close()
+		return fmt.Errorf("Can not connect to %s", this.Url.Host)
+	}
+	return nil
+}
+
+//Report of completed test
+func (this *testRun) report(interrupted bool) Report {
+	return Report{
+		Config:      this.Config,
+		Stats:       this.stats,
+		Thresholds:  CheckThresholds(this.stats, this.Thresholds),
+		Interrupted: interrupted,
+	}
+}
+
+//Wait in flight responses up to drain timeout, not answered requests are counted as abandoned.
+//Drain is not stopped by context, connections are closed by caller
+func (this *testRun) drain() {
+	if this.DrainTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), this.DrainTimeout)
+		defer cancel()
+		this.manager.Wait(ctx.Done())
+	}
+	atomic.StoreInt32(&this.counters.AbandonedRequests, int32(this.manager.Pending()))
+}
+
+//Start threads and stats aggregator for duration, returns false if test was interrupted by context
+func runLoad(ctx context.Context, config *testRun, duration time.Duration) bool {
+	config.started = time.Now()
+	go startStatsAggregator(config)
+
+	for i := 0; i < config.Threads; i++ {
+		go newThread(config, i)
+	}
+	rampStop := make(chan bool)
+	if config.Stages.HasConnections() {
+		go rampConnections(config, rampStop)
+	}
+
+	//Wait timers, request budget or cancel, 0 duration is unlimited
+	var timer <-chan time.Time
+	if duration > 0 {
+		timer = time.After(duration)
+	}
+	completed := true
+	select {
+	case <-timer:
+	case <-config.manager.Done:
+	case <-ctx.Done():
+		completed = false
+	}
+	for i := 0; i < config.Threads; i++ {
+		config.workerQuit <- true
+	}
+	//Wait for threads complete
+	for i := 0; i < config.Threads; i++ {
+		<-config.workerQuited
+	}
+	close(rampStop)
+	//Wait responses of budgeted test, cancel stops waiting
+	if completed && (config.Requests > 0 || config.PerConnection > 0) {
+		completed = config.manager.Wait(ctx.Done())
+	}
+	config.drain()
+
+	//Stop stats aggregator and wait it complete
+	config.statsQuit <- true
+	<-config.statsQuited
+	return completed
+}
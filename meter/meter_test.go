@@ -0,0 +1,126 @@
+package meter
+
+import (
+	"context"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunConfigNotChanged(t *testing.T) {
+	source := &Source{Data: [][]byte{[]byte("/items/{{seq}}")}}
+	group := &RequestGroup{Name: "health", Weight: 1}
+	config := Config{
+		Url:         startTestServer(t, nil),
+		Template:    true,
+		Connections: 1,
+		Threads:     1,
+		Requests:    5,
+		Source:      source,
+	}
+	for i := 0; i < 2; i++ {
+		if stats := runTest(t, config, 5*time.Second).Stats; stats.Requests != 5 {
+			t.Errorf("run %d: requests %d", i, stats.Requests)
+		}
+	}
+	if config.Source != source || len(source.Data) != 1 || len(source.Requests) != 0 || source.seq != 0 {
+		t.Errorf("source is changed: %+v", source)
+	}
+
+	config.Source = nil
+	config.Groups = []*RequestGroup{group}
+	config.Stages = Stages{{Duration: 200 * time.Millisecond, Rate: 10}}
+	config.Requests = 0
+	runTest(t, config, 5*time.Second)
+	if group.Source != nil || config.Groups[0] != group || config.Duration != 0 {
+		t.Errorf("group %+v, duration %v", group, config.Duration)
+	}
+}
+
+func TestRunEmptyBody(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		lengths []int64
+	)
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			lock.Lock()
+			lengths = append(lengths, r.ContentLength+int64(len(body)))
+			lock.Unlock()
+		}),
+		Method:      "POST",
+		Connections: 1,
+		Threads:     1,
+		Requests:    2,
+	}
+	//POST without source and group without source are sent with empty body
+	if stats := runTest(t, config, 5*time.Second).Stats; stats.Codes[200] != 2 {
+		t.Errorf("no source: codes %v", stats.Codes)
+	}
+	config.Method = "PUT"
+	config.Groups = []*RequestGroup{{Name: "empty", Weight: 1}}
+	if stats := runTest(t, config, 5*time.Second).Stats; stats.Codes[200] != 2 || stats.Groups[0].Requests != 2 {
+		t.Errorf("group without source: codes %v", stats.Codes)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual(lengths, []int64{0, 0, 0, 0}) {
+		t.Errorf("body lengths %v", lengths)
+	}
+}
+
+func TestRunDrainCanceled(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+	blocked := startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		<-release
+	})
+	slow := startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		time.Sleep(150 * time.Millisecond)
+	})
+	tests := []struct {
+		name  string
+		url   *url.URL
+		drain time.Duration
+		//Min and max run time after cancel
+		min, max time.Duration
+		answered bool
+	}{
+		//Not answered requests are abandoned after drain timeout
+		{"blocked", blocked, 300 * time.Millisecond, 300 * time.Millisecond, time.Second, false},
+		//Negative drain timeout does not wait
+		{"no drain", blocked, -1, 0, 200 * time.Millisecond, false},
+		//In flight requests are awaited and counted
+		{"slow", slow, 2 * time.Second, 0, time.Second, true},
+	}
+	for _, test := range tests {
+		config := Config{
+			Url:          test.url,
+			Connections:  2,
+			Threads:      1,
+			MRQ:          8,
+			DrainTimeout: test.drain,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+		start := time.Now()
+		report, err := Run(ctx, config)
+		elapsed := time.Now().Sub(start) - 200*time.Millisecond
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats := report.Stats
+		if !report.Interrupted || elapsed < test.min || elapsed > test.max {
+			t.Errorf("%s: interrupted %v, drained in %v", test.name, report.Interrupted, elapsed)
+		}
+		if test.answered && (stats.AbandonedRequests != 0 || stats.Requests == 0) || !test.answered && (stats.AbandonedRequests == 0 || stats.Requests != 0) {
+			t.Errorf("%s: requests %d, abandoned %d", test.name, stats.Requests, stats.AbandonedRequests)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package meter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Upper bounds of latency histogram buckets in seconds
+var metricsBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//Live counters in Prometheus text exposition format, counters are not reset between search windows
+type Metrics struct {
+	lock     sync.Mutex
+	requests int64
+	skipped  int64
+	readed   int64
+	writed   int64
+	codes    map[int]int64
+	errors   ErrorCounters
+	//Cumulative counts of metricsBuckets
+	buckets []int64
+	count   int64
+	sum     time.Duration
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		codes:   map[int]int64{},
+		buckets: make([]int64, len(metricsBuckets)),
+	}
+}
+
+//Add response, latency is not stored for excluded seconds
+func (this *Metrics) Add(res *RequestStats, latency bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.requests++
+	this.readed += res.NetIn
+	this.writed += res.NetOut
+	this.codes[res.ResponseCode]++
+	if !latency {
+		this.skipped++
+		return
+	}
+	seconds := res.Duration.Seconds()
+	for i, bound := range metricsBuckets {
+		if seconds <= bound {
+			this.buckets[i]++
+		}
+	}
+	this.count++
+	this.sum += res.Duration
+}
+
+func (this *Metrics) AddErrors(errors ErrorCounters) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.errors.Add(errors)
+}
+
+//Add per second stats of agent
+func (this *Metrics) AddSecond(stats *StatsSourcePerSecond) {
+	this.AddErrors(stats.Errors)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.requests += int64(stats.Requests)
+	this.skipped += int64(stats.Skiped)
+	this.readed += stats.Readed
+	this.writed += stats.Writed
+	for code, count := range stats.Codes {
+		this.codes[code] += int64(count)
+	}
+	if stats.Latency == nil {
+		return
+	}
+	for i, bound := range metricsBuckets {
+		this.buckets[i] += stats.Latency.CountAtOrBelow(time.Duration(bound * float64(time.Second)))
+	}
+	this.count += stats.Latency.Count()
+	this.sum += stats.Latency.Sum()
+}
+
+func (this *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	this.lock.Lock()
+	writeMetric(buf, "gometer_requests_total", "counter", "Completed requests.", this.requests)
+	writeMetric(buf, "gometer_skipped_requests_total", "counter", "Completed requests excluded from latency stats.", this.skipped)
+	writeMetric(buf, "gometer_bytes_in_total", "counter", "Received bytes.", this.readed)
+	writeMetric(buf, "gometer_bytes_out_total", "counter", "Sent bytes.", this.writed)
+
+	fmt.Fprintf(buf, "# HELP gometer_responses_total Responses by HTTP code.\n# TYPE gometer_responses_total counter\n")
+	codes := make([]int, 0, len(this.codes))
+	for code := range this.codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(buf, "gometer_responses_total{code=\"%d\"} %d\n", code, this.codes[code])
+	}
+
+	fmt.Fprintf(buf, "# HELP gometer_errors_total Connection, read, write and timeout errors.\n# TYPE gometer_errors_total counter\n")
+	fmt.Fprintf(buf, "gometer_errors_total{type=\"connection\"} %d\n", this.errors.Connection)
+	fmt.Fprintf(buf, "gometer_errors_total{type=\"read\"} %d\n", this.errors.Read)
+	fmt.Fprintf(buf, "gometer_errors_total{type=\"write\"} %d\n", this.errors.Write)
+	fmt.Fprintf(buf, "gometer_errors_total{type=\"timeout\"} %d\n", this.errors.Timeout)
+
+	fmt.Fprintf(buf, "# HELP gometer_request_duration_seconds Request latency.\n# TYPE gometer_request_duration_seconds histogram\n")
+	for i, bound := range metricsBuckets {
+		fmt.Fprintf(buf, "gometer_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), this.buckets[i])
+	}
+	fmt.Fprintf(buf, "gometer_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", this.count)
+	fmt.Fprintf(buf, "gometer_request_duration_seconds_sum %s\n", strconv.FormatFloat(this.sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(buf, "gometer_request_duration_seconds_count %d\n", this.count)
+	this.lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+func writeMetric(buf *bytes.Buffer, name string, kind string, help string, value int64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+//Serve metrics on /metrics in background, listen error is returned
+func StartMetricsServer(addr string, metrics *Metrics) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go http.Serve(listener, mux)
+	return nil
+}
@@ -0,0 +1,107 @@
+package meter
+
+import (
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsServeHTTP(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Add(&RequestStats{ResponseCode: 200, Duration: 300 * time.Microsecond, NetIn: 100, NetOut: 10}, true)
+	metrics.Add(&RequestStats{ResponseCode: 200, Duration: 3 * time.Millisecond, NetIn: 100, NetOut: 10}, true)
+	metrics.Add(&RequestStats{ResponseCode: 503, Duration: 20 * time.Second, NetIn: 50, NetOut: 10}, true)
+	//Excluded second is counted without latency
+	metrics.Add(&RequestStats{ResponseCode: 200, Duration: time.Second, NetIn: 100, NetOut: 10}, false)
+	metrics.AddErrors(ErrorCounters{Connection: 1, Timeout: 2})
+	//Agent second
+	second := newStatsSourcePerSecond(NewHistogram(DefaultHistogramPrecision))
+	second.Requests, second.Readed, second.Writed = 2, 200, 20
+	second.Codes = map[int]int{404: 2}
+	second.Errors = ErrorCounters{Read: 3, Write: 4}
+	second.Latency.Record(40 * time.Millisecond)
+	second.Latency.Record(2 * time.Second)
+	metrics.AddSecond(&second)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4" {
+		t.Errorf("content type %s", contentType)
+	}
+	expected := `# HELP gometer_requests_total Completed requests.
+# TYPE gometer_requests_total counter
+gometer_requests_total 6
+# HELP gometer_skipped_requests_total Completed requests excluded from latency stats.
+# TYPE gometer_skipped_requests_total counter
+gometer_skipped_requests_total 1
+# HELP gometer_bytes_in_total Received bytes.
+# TYPE gometer_bytes_in_total counter
+gometer_bytes_in_total 550
+# HELP gometer_bytes_out_total Sent bytes.
+# TYPE gometer_bytes_out_total counter
+gometer_bytes_out_total 60
+# HELP gometer_responses_total Responses by HTTP code.
+# TYPE gometer_responses_total counter
+gometer_responses_total{code="200"} 3
+gometer_responses_total{code="404"} 2
+gometer_responses_total{code="503"} 1
+# HELP gometer_errors_total Connection, read, write and timeout errors.
+# TYPE gometer_errors_total counter
+gometer_errors_total{type="connection"} 1
+gometer_errors_total{type="read"} 3
+gometer_errors_total{type="write"} 4
+gometer_errors_total{type="timeout"} 2
+# HELP gometer_request_duration_seconds Request latency.
+# TYPE gometer_request_duration_seconds histogram
+gometer_request_duration_seconds_bucket{le="0.0005"} 1
+gometer_request_duration_seconds_bucket{le="0.001"} 1
+gometer_request_duration_seconds_bucket{le="0.0025"} 1
+gometer_request_duration_seconds_bucket{le="0.005"} 2
+gometer_request_duration_seconds_bucket{le="0.01"} 2
+gometer_request_duration_seconds_bucket{le="0.025"} 2
+gometer_request_duration_seconds_bucket{le="0.05"} 3
+gometer_request_duration_seconds_bucket{le="0.1"} 3
+gometer_request_duration_seconds_bucket{le="0.25"} 3
+gometer_request_duration_seconds_bucket{le="0.5"} 3
+gometer_request_duration_seconds_bucket{le="1"} 3
+gometer_request_duration_seconds_bucket{le="2.5"} 4
+gometer_request_duration_seconds_bucket{le="5"} 4
+gometer_request_duration_seconds_bucket{le="10"} 4
+gometer_request_duration_seconds_bucket{le="+Inf"} 5
+gometer_request_duration_seconds_sum 22.0433
+gometer_request_duration_seconds_count 5
+`
+	if body := recorder.Body.String(); body != expected {
+		t.Errorf("metrics:\n%s\nwant:\n%s", body, expected)
+	}
+}
+
+func TestStartMetricsServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	metrics := NewMetrics()
+	metrics.Add(&RequestStats{ResponseCode: 200}, true)
+	if err = StartMetricsServer(addr, metrics); err != nil {
+		t.Fatal(err)
+	}
+	res, err := nethttp.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || !strings.Contains(string(body), "\ngometer_requests_total 1\n") {
+		t.Errorf("status %d, metrics:\n%s", res.StatusCode, body)
+	}
+	if err = StartMetricsServer(addr, metrics); err == nil {
+		t.Error("address in use: expected error")
+	}
+}
@@ -0,0 +1,78 @@
+package meter
+
+import (
+	"fmt"
+	"io"
+)
+
+//Latency distributions of request phases
+type PhaseStats struct {
+	//Request write time
+	Write *Histogram
+	//Time from write end to first response byte
+	Wait *Histogram
+	//Time from first byte to headers end
+	Headers *Histogram
+	//Body read time
+	Body *Histogram
+}
+
+func NewPhaseStats(precision int) PhaseStats {
+	return PhaseStats{
+		Write:   NewHistogram(precision),
+		Wait:    NewHistogram(precision),
+		Headers: NewHistogram(precision),
+		Body:    NewHistogram(precision),
+	}
+}
+
+func (this *PhaseStats) Record(res *RequestStats) {
+	this.Write.Record(res.Write)
+	this.Wait.Record(res.Wait)
+	this.Headers.Record(res.Headers)
+	this.Body.Record(res.Body)
+}
+
+func (this *PhaseStats) Merge(other *PhaseStats) {
+	if other.Write == nil {
+		return
+	}
+	if this.Write == nil {
+		*this = NewPhaseStats(other.Write.Precision())
+	}
+	this.Write.Merge(other.Write)
+	this.Wait.Merge(other.Wait)
+	this.Headers.Merge(other.Headers)
+	this.Body.Merge(other.Body)
+}
+
+//Phase names and histograms in request order
+func (this *PhaseStats) Items() ([]string, []*Histogram) {
+	return []string{"Write", "Wait", "Headers", "Body"}, []*Histogram{this.Write, this.Wait, this.Headers, this.Body}
+}
+
+//Print per phase latency distributions
+func PrintPhases(w io.Writer, source *StatsSource) {
+	if source.Phases.Write == nil || source.Phases.Write.Count() == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Phases: \n     %v %v %v %v %v %v\n",
+		newSpacesFormatRightf("Phase", 9, "%s"),
+		newSpacesFormat("Min", 10),
+		newSpacesFormat("P50", 10),
+		newSpacesFormat("P90", 10),
+		newSpacesFormat("P99", 10),
+		newSpacesFormat("Max", 10),
+	)
+	names, histograms := source.Phases.Items()
+	for i, histogram := range histograms {
+		fmt.Fprintf(w, "     %v %v %v %v %v %v\n",
+			newSpacesFormatRightf(names[i], 9, "%s"),
+			newSpacesFormat(roundMicroDuration(histogram.Min()), 10),
+			newSpacesFormat(roundMicroDuration(histogram.Percentile(50)), 10),
+			newSpacesFormat(roundMicroDuration(histogram.Percentile(90)), 10),
+			newSpacesFormat(roundMicroDuration(histogram.Percentile(99)), 10),
+			newSpacesFormat(roundMicroDuration(histogram.Max()), 10),
+		)
+	}
+}
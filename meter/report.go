@@ -0,0 +1,326 @@
+package meter
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+//Version of JSON report schema, increment on incompatible changes
+const JSONReportVersion = 1
+
+//JSON report, durations are in milliseconds, see README for schema
+type JSONReport struct {
+	Version     int             `json:"version"`
+	Config      JSONConfig      `json:"config"`
+	Requests    int             `json:"requests"`
+	Skipped     int             `json:"skipped"`
+	Duration    float64         `json:"duration_ms"`
+	Latency     JSONLatency     `json:"latency"`
+	Phases      *JSONPhases     `json:"phases,omitempty"`
+	Connect     *JSONDuration   `json:"connect,omitempty"`
+	Handshake   *JSONDuration   `json:"handshake,omitempty"`
+	StatusCodes map[string]int  `json:"status_codes"`
+	Errors      JSONErrors      `json:"errors"`
+	Bytes       JSONBytes       `json:"bytes"`
+	Throughput  JSONThroughput  `json:"throughput"`
+	Thresholds  []JSONThreshold `json:"thresholds,omitempty"`
+	Stages      []JSONStage     `json:"stages,omitempty"`
+	Groups      []JSONGroup     `json:"groups,omitempty"`
+}
+
+type JSONConfig struct {
+	Method           string   `json:"method"`
+	URL              string   `json:"url"`
+	Connections      int      `json:"connections"`
+	Threads          int      `json:"threads"`
+	MRQ              int      `json:"mrq"`
+	Rate             int      `json:"rate"`
+	Requests         int      `json:"requests,omitempty"`
+	PerConnection    int      `json:"per_connection,omitempty"`
+	Reconnect        bool     `json:"reconnect"`
+	ConnectTimeout   float64  `json:"connect_timeout_ms,omitempty"`
+	WriteTimeout     float64  `json:"write_timeout_ms,omitempty"`
+	FirstByteTimeout float64  `json:"ttfb_timeout_ms,omitempty"`
+	ResponseTimeout  float64  `json:"timeout_ms,omitempty"`
+	DrainTimeout     float64  `json:"drain_timeout_ms"`
+	Duration         float64  `json:"duration_ms"`
+	ExcludeSeconds   float64  `json:"exclude_ms"`
+	Precision        int      `json:"precision"`
+	Agents           []string `json:"agents,omitempty"`
+	//Source file, empty for config URL or inline requests
+	Source   string `json:"source,omitempty"`
+	Template bool   `json:"template,omitempty"`
+	//Values of credential headers are redacted
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Stages     []JSONConfigStage   `json:"stages,omitempty"`
+	Groups     []JSONConfigGroup   `json:"groups,omitempty"`
+	Thresholds []string            `json:"thresholds,omitempty"`
+}
+
+type JSONConfigStage struct {
+	Duration    float64 `json:"duration_ms"`
+	Rate        int     `json:"rate"`
+	Connections int     `json:"connections,omitempty"`
+}
+
+type JSONConfigGroup struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	Source string `json:"source,omitempty"`
+}
+
+type JSONThreshold struct {
+	Expr   string  `json:"expr"`
+	Actual float64 `json:"actual"`
+	Pass   bool    `json:"pass"`
+}
+
+type JSONStage struct {
+	//Index in config stages, stages without requests after stats reset are skipped
+	Index       int         `json:"index"`
+	Duration    float64     `json:"duration_ms"`
+	Target      int         `json:"target_rate"`
+	Connections int         `json:"target_connections,omitempty"`
+	Requests    int         `json:"requests"`
+	Latency     JSONLatency `json:"latency"`
+	Errors      int         `json:"errors"`
+	Bytes       JSONBytes   `json:"bytes"`
+}
+
+type JSONGroup struct {
+	Name        string          `json:"name"`
+	Weight      int             `json:"weight"`
+	Requests    int             `json:"requests"`
+	Rate        float64         `json:"requests_per_sec"`
+	Latency     JSONLatency     `json:"latency"`
+	StatusCodes map[string]int  `json:"status_codes"`
+	Errors      JSONGroupErrors `json:"errors"`
+	Bytes       JSONBytes       `json:"bytes"`
+}
+
+type JSONGroupErrors struct {
+	Connection int `json:"connection"`
+	Read       int `json:"read"`
+	Write      int `json:"write"`
+	Timeout    int `json:"timeout"`
+}
+
+type JSONPhases struct {
+	Write   JSONLatency `json:"write"`
+	Wait    JSONLatency `json:"wait"`
+	Headers JSONLatency `json:"headers"`
+	Body    JSONLatency `json:"body"`
+}
+
+type JSONDuration struct {
+	Min  float64 `json:"min_ms"`
+	Mean float64 `json:"mean_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+type JSONLatency struct {
+	JSONDuration
+	//Keys are "p50", "p99.9", ...
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+type JSONErrors struct {
+	Connection  int `json:"connection"`
+	Read        int `json:"read"`
+	Write       int `json:"write"`
+	Timeout     int `json:"timeout"`
+	Reconnects  int `json:"reconnects"`
+	LateSends   int `json:"late_sends"`
+	MissedSends int `json:"missed_sends"`
+	Abandoned   int `json:"abandoned"`
+}
+
+type JSONBytes struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+type JSONThroughput struct {
+	Requests float64 `json:"requests_per_sec"`
+	BytesIn  float64 `json:"bytes_in_per_sec"`
+	BytesOut float64 `json:"bytes_out_per_sec"`
+}
+
+//Build JSON report from collected statistic
+func NewJSONReport(report *Report) *JSONReport {
+	source, config := report.Stats, report.Config
+	result := &JSONReport{
+		Version: JSONReportVersion,
+		Config: JSONConfig{
+			Method:           config.Method,
+			URL:              config.Url.String(),
+			Connections:      config.Connections,
+			Threads:          config.Threads,
+			MRQ:              config.MRQ,
+			Rate:             config.Rate,
+			Requests:         config.Requests,
+			PerConnection:    config.PerConnection,
+			Reconnect:        config.Reconnect,
+			ConnectTimeout:   milliseconds(config.ConnectTimeout),
+			WriteTimeout:     milliseconds(config.WriteTimeout),
+			FirstByteTimeout: milliseconds(config.FirstByteTimeout),
+			ResponseTimeout:  milliseconds(config.ResponseTimeout),
+			DrainTimeout:     milliseconds(config.DrainTimeout),
+			Duration:         milliseconds(config.Duration),
+			ExcludeSeconds:   milliseconds(config.ExcludeSeconds),
+			Precision:        config.Precision,
+			Agents:           config.Agents,
+			Template:         config.Template,
+			Headers:          redactHeaders(config.Header),
+		},
+		Requests:    source.Requests,
+		Skipped:     source.Skiped,
+		Duration:    milliseconds(source.Work),
+		StatusCodes: map[string]int{},
+		Errors: JSONErrors{
+			Connection:  source.ConnectionErrors,
+			Read:        source.ReadErrors,
+			Write:       source.WriteErrors,
+			Timeout:     source.TimeoutErrors,
+			Reconnects:  source.Reconnects,
+			LateSends:   source.LateRequests,
+			MissedSends: source.MissedRequests,
+			Abandoned:   source.AbandonedRequests,
+		},
+		Bytes: JSONBytes{
+			In:  source.Readed,
+			Out: source.Writed,
+		},
+	}
+	if config.Source != nil {
+		result.Config.Source = config.Source.File
+	}
+	for _, stage := range config.Stages {
+		result.Config.Stages = append(result.Config.Stages, JSONConfigStage{Duration: milliseconds(stage.Duration), Rate: stage.Rate, Connections: stage.Connections})
+	}
+	for _, group := range config.Groups {
+		item := JSONConfigGroup{Name: group.Name, Weight: group.Weight}
+		if group.Source != nil {
+			item.Source = group.Source.File
+		}
+		result.Config.Groups = append(result.Config.Groups, item)
+	}
+	for _, threshold := range config.Thresholds {
+		result.Config.Thresholds = append(result.Config.Thresholds, threshold.Expr)
+	}
+	result.Latency = newJSONLatency(source.Latency)
+	if phases := source.Phases; phases.Write != nil && phases.Write.Count() > 0 {
+		result.Phases = &JSONPhases{
+			Write:   newJSONLatency(phases.Write),
+			Wait:    newJSONLatency(phases.Wait),
+			Headers: newJSONLatency(phases.Headers),
+			Body:    newJSONLatency(phases.Body),
+		}
+	}
+	for code, count := range source.Codes {
+		result.StatusCodes[strconv.Itoa(code)] = count
+	}
+	result.Connect = newJSONDuration(&source.Connect)
+	result.Handshake = newJSONDuration(&source.Handshake)
+	for _, threshold := range report.Thresholds {
+		result.Thresholds = append(result.Thresholds, JSONThreshold{
+			Expr:   threshold.Threshold.Expr,
+			Actual: threshold.Actual,
+			Pass:   threshold.Pass,
+		})
+	}
+	for i, stats := range source.Stages {
+		if stats == nil {
+			continue
+		}
+		result.Stages = append(result.Stages, JSONStage{
+			Index:       i,
+			Duration:    milliseconds(config.Stages[i].Duration),
+			Target:      config.Stages[i].Rate,
+			Connections: config.Stages[i].Connections,
+			Requests:    stats.Requests,
+			Latency:     newJSONLatency(stats.Latency),
+			Errors:      stats.Errors.Total(),
+			Bytes: JSONBytes{
+				In:  stats.Readed,
+				Out: stats.Writed,
+			},
+		})
+	}
+	for _, stats := range source.Groups {
+		group := JSONGroup{
+			Name:        stats.Name,
+			Weight:      stats.Weight,
+			Requests:    stats.Requests,
+			Rate:        perSecond(stats.Requests, source.Work),
+			Latency:     newJSONLatency(stats.Latency),
+			StatusCodes: map[string]int{},
+			Errors: JSONGroupErrors{
+				Connection: stats.Errors.Connection,
+				Read:       stats.Errors.Read,
+				Write:      stats.Errors.Write,
+				Timeout:    stats.Errors.Timeout,
+			},
+			Bytes: JSONBytes{
+				In:  stats.Readed,
+				Out: stats.Writed,
+			},
+		}
+		for code, count := range stats.Codes {
+			group.StatusCodes[strconv.Itoa(code)] = count
+		}
+		result.Groups = append(result.Groups, group)
+	}
+	if seconds := source.Work.Seconds(); seconds > 0 {
+		result.Throughput = JSONThroughput{
+			Requests: float64(source.Requests) / seconds,
+			BytesIn:  float64(source.Readed) / seconds,
+			BytesOut: float64(source.Writed) / seconds,
+		}
+	}
+	return result
+}
+
+func newJSONLatency(latency *Histogram) JSONLatency {
+	result := JSONLatency{
+		JSONDuration: JSONDuration{
+			Min:  milliseconds(latency.Min()),
+			Mean: milliseconds(latency.Mean()),
+			Max:  milliseconds(latency.Max()),
+		},
+		Percentiles: map[string]float64{},
+	}
+	for _, percentile := range reportPercentiles {
+		key := "p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+		result.Percentiles[key] = milliseconds(latency.Percentile(percentile))
+	}
+	return result
+}
+
+func newJSONDuration(stats *DurationStats) *JSONDuration {
+	if stats.Count == 0 {
+		return nil
+	}
+	return &JSONDuration{
+		Min:  milliseconds(stats.Min),
+		Mean: milliseconds(stats.Avg()),
+		Max:  milliseconds(stats.Max),
+	}
+}
+
+//Write JSON report
+func PrintJSONStats(w io.Writer, report *Report) error {
+	data, err := json.MarshalIndent(NewJSONReport(report), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,122 @@
+package meter
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Sorted keys of JSON object
+func jsonKeys(t *testing.T, value interface{}) string {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		t.Fatalf("%v is not an object", value)
+	}
+	var keys []string
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
+
+func TestJSONReportSchema(t *testing.T) {
+	threshold, _ := ParseThreshold("p99<1s")
+	config := Config{
+		Url:         startTestServer(t, nil),
+		Header:      map[string][]string{"Accept": {"*/*"}, "Authorization": {"Bearer secret"}, "Cookie": {"id=secret"}, "X-Api-Key": {"secret"}},
+		Connections: 1,
+		Threads:     1,
+		Stages:      Stages{{Duration: 300 * time.Millisecond, Rate: 20}},
+		Groups:      []*RequestGroup{{Name: "all", Weight: 1}},
+		Thresholds:  []*Threshold{threshold},
+	}
+	report := runTest(t, config, 5*time.Second)
+	buf := &bytes.Buffer{}
+	if err := PrintJSONStats(buf, &report); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("credentials in report:\n%s", buf.String())
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["version"] != float64(1) {
+		t.Errorf("version %v", result["version"])
+	}
+
+	latency := "max_ms mean_ms min_ms percentiles"
+	percentiles := "p50 p75 p90 p99 p99.9 p99.99"
+	bytesKeys := "in out"
+	config0 := result["config"].(map[string]interface{})
+	stage := result["stages"].([]interface{})[0].(map[string]interface{})
+	group := result["groups"].([]interface{})[0].(map[string]interface{})
+	phases := result["phases"].(map[string]interface{})
+	tests := []struct {
+		name  string
+		value interface{}
+		keys  string
+	}{
+		{"report", result, "bytes config connect duration_ms errors groups latency phases requests skipped stages status_codes thresholds throughput version"},
+		{"config", config0, "connections drain_timeout_ms duration_ms exclude_ms groups headers method mrq precision rate reconnect stages threads thresholds url"},
+		{"config.headers", config0["headers"], "Accept Authorization Cookie X-Api-Key"},
+		{"config.stages", config0["stages"].([]interface{})[0], "duration_ms rate"},
+		{"config.groups", config0["groups"].([]interface{})[0], "name weight"},
+		{"latency", result["latency"], latency},
+		{"latency.percentiles", result["latency"].(map[string]interface{})["percentiles"], percentiles},
+		{"phases", phases, "body headers wait write"},
+		{"phases.wait", phases["wait"], latency},
+		{"connect", result["connect"], "max_ms mean_ms min_ms"},
+		{"status_codes", result["status_codes"], "200"},
+		{"errors", result["errors"], "abandoned connection late_sends missed_sends read reconnects timeout write"},
+		{"bytes", result["bytes"], bytesKeys},
+		{"throughput", result["throughput"], "bytes_in_per_sec bytes_out_per_sec requests_per_sec"},
+		{"thresholds", result["thresholds"].([]interface{})[0], "actual expr pass"},
+		{"stages", stage, "bytes duration_ms errors index latency requests target_rate"},
+		{"stages.latency", stage["latency"], latency},
+		{"stages.bytes", stage["bytes"], bytesKeys},
+		{"groups", group, "bytes errors latency name requests requests_per_sec status_codes weight"},
+		{"groups.errors", group["errors"], "connection read timeout write"},
+		{"groups.latency", group["latency"], latency},
+	}
+	for _, test := range tests {
+		if keys := jsonKeys(t, test.value); keys != test.keys {
+			t.Errorf("%s keys:\n%s\nwant:\n%s", test.name, keys, test.keys)
+		}
+	}
+
+	//Credential header values are redacted, other headers are kept
+	headers := config0["headers"].(map[string]interface{})
+	for key, value := range map[string]string{"Accept": "*/*", "Authorization": "REDACTED", "Cookie": "REDACTED", "X-Api-Key": "REDACTED"} {
+		if values := headers[key].([]interface{}); len(values) != 1 || values[0] != value {
+			t.Errorf("header %s: %v", key, values)
+		}
+	}
+	if config.Header["Authorization"][0] != "Bearer secret" {
+		t.Error("config headers are changed")
+	}
+}
+
+func TestIsSecretHeader(t *testing.T) {
+	for key, secret := range map[string]bool{
+		"Authorization":       true,
+		"proxy-authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+		"X-Api-Key":           true,
+		"X-Auth-Token":        true,
+		"X-Session-Id":        true,
+		"Accept":              false,
+		"Content-Type":        false,
+		"Host":                false,
+	} {
+		if isSecretHeader(key) != secret {
+			t.Errorf("isSecretHeader(%q) = %v", key, !secret)
+		}
+	}
+}
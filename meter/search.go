@@ -0,0 +1,308 @@
+package meter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+//Responses of previous window are awaited and dropped before next window
+const searchDrainPoll = time.Duration(10) * time.Millisecond
+
+//Capacity search settings
+type SearchOptions struct {
+	//"step" or "binary"
+	Mode string
+	//First tested rate
+	Start int
+	//Step mode rate increment, binary mode precision
+	Step int
+	//Max tested rate, 0 for unlimited in step mode
+	Max int
+	//Test time of every rate
+	Window time.Duration
+	//Rate is sustainable when all thresholds pass
+	SLO []*Threshold
+}
+
+//Result of one tested rate
+type SearchLevel struct {
+	Rate       int
+	Requests   int
+	Throughput float64
+	P50        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	//Errors percent of all requests
+	Errors  float64
+	Results []*ThresholdResult
+	Pass    bool
+}
+
+type SearchResult struct {
+	Options *SearchOptions
+	//Tested rates in test order
+	Levels []*SearchLevel
+	//Max sustainable level, nil if start rate fails
+	Best *SearchLevel
+	//Search was interrupted by signal
+	Interrupted bool
+}
+
+func (this *SearchOptions) Validate() error {
+	if this.Mode != "step" && this.Mode != "binary" {
+		return fmt.Errorf("unknown search mode %s, use step or binary", this.Mode)
+	}
+	if len(this.SLO) == 0 {
+		return errors.New("search requires -search-slo")
+	}
+	if this.Start <= 0 || this.Step <= 0 {
+		return errors.New("search start and step must be positive")
+	}
+	if this.Mode == "binary" && this.Max <= this.Start {
+		return errors.New("binary search requires -search-max greater than -search-start")
+	}
+	if this.Window <= 0 {
+		return errors.New("search window must be positive")
+	}
+	return nil
+}
+
+//Find max rate passing SLO, every rate is tested with open model for window time
+func RunSearch(ctx context.Context, config Config, options *SearchOptions) (*SearchResult, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if len(config.Stages) > 0 || len(config.Agents) > 0 || config.Requests > 0 || config.PerConnection > 0 {
+		return nil, errors.New("search can not be used with stages, agents or request budgets")
+	}
+	config.Rate = options.Start
+	run, err := config.prepare()
+	if err != nil {
+		return nil, err
+	}
+	if err = run.connect(); err != nil {
+		return nil, err
+	}
+	defer run.manager.Close()
+	return runSearch(ctx, run, options), nil
+}
+
+func runSearch(ctx context.Context, config *testRun, options *SearchOptions) *SearchResult {
+	result := &SearchResult{Options: options}
+	//Test rate, returns false if search must be stopped
+	test := func(rate int) (*SearchLevel, bool) {
+		if len(result.Levels) > 0 && !drainSearchLevel(ctx, config, options.Window) {
+			result.Interrupted = true
+			return nil, false
+		}
+		level := runSearchLevel(ctx, config, options, rate)
+		if level == nil {
+			result.Interrupted = true
+			return nil, false
+		}
+		result.Levels = append(result.Levels, level)
+		if level.Pass && (result.Best == nil || result.Best.Rate < level.Rate) {
+			result.Best = level
+		}
+		return level, true
+	}
+
+	if options.Mode == "step" {
+		for rate := options.Start; options.Max == 0 || rate <= options.Max; rate += options.Step {
+			level, ok := test(rate)
+			if !ok || !level.Pass {
+				break
+			}
+		}
+		return result
+	}
+
+	//Binary search between last passed and first failed rate
+	level, ok := test(options.Start)
+	if !ok || !level.Pass {
+		return result
+	}
+	if level, ok = test(options.Max); !ok || level.Pass {
+		return result
+	}
+	low, high := options.Start, options.Max
+	for high-low > options.Step {
+		rate := (low + high) / 2
+		if level, ok = test(rate); !ok {
+			return result
+		}
+		if level.Pass {
+			low = rate
+		} else {
+			high = rate
+		}
+	}
+	return result
+}
+
+//Run one search window with clear stats, returns nil if interrupted
+func runSearchLevel(ctx context.Context, config *testRun, options *SearchOptions, rate int) *SearchLevel {
+	config.resetStats()
+	config.Rate = rate
+	fmt.Fprintf(config.Log, "Testing rate: %d req/sec in %v\n", rate, options.Window)
+	if !runLoad(ctx, config, options.Window) {
+		return nil
+	}
+	source := config.stats
+	level := &SearchLevel{
+		Rate:     rate,
+		Requests: source.Requests,
+		P50:      source.Latency.Percentile(50),
+		P99:      source.Latency.Percentile(99),
+		Max:      source.Latency.Max(),
+		Results:  CheckThresholds(source, options.SLO),
+		Pass:     true,
+	}
+	if source.Work.Seconds() > 0 {
+		level.Throughput = float64(source.Requests) / source.Work.Seconds()
+	}
+	count := source.Errors().Total()
+	level.Errors = getPercentOrZero(count, source.Requests+count)
+	for _, threshold := range level.Results {
+		level.Pass = level.Pass && threshold.Pass
+	}
+	status := "pass"
+	if !level.Pass {
+		status = "fail"
+	}
+	fmt.Fprintf(config.Log, "  %.2f req/sec, p50 %v, p99 %v, errors %.2f%% - %s\n", level.Throughput, roundMicroDuration(level.P50), roundMicroDuration(level.P99), level.Errors, status)
+	return level
+}
+
+//Wait responses of previous window up to timeout and drop them, returns false if interrupted
+func drainSearchLevel(ctx context.Context, config *testRun, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(searchDrainPoll)
+	defer ticker.Stop()
+	for {
+		for len(config.requestStats) > 0 {
+			<-config.requestStats
+		}
+		if config.manager.Pending() == 0 {
+			return true
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			fmt.Fprintf(config.Log, "  %d requests of previous rate are not completed\n", config.manager.Pending())
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+//Print rate vs latency curve and max sustainable rate
+func PrintSearch(w io.Writer, result *SearchResult) {
+	fmt.Fprintf(w, "Search: \n     %v %v %v %v %v %v %v %v\n",
+		newSpacesFormat("Rate", 8),
+		newSpacesFormat("Requests", 10),
+		newSpacesFormat("Req/sec", 10),
+		newSpacesFormat("P50", 10),
+		newSpacesFormat("P99", 10),
+		newSpacesFormat("Max", 10),
+		newSpacesFormat("Errors", 8),
+		newSpacesFormat("SLO", 5),
+	)
+	for _, level := range result.Levels {
+		status := "pass"
+		if !level.Pass {
+			status = "fail"
+		}
+		fmt.Fprintf(w, "     %v %v %v %v %v %v %v %v\n",
+			newSpacesFormat(level.Rate, 8),
+			newSpacesFormat(level.Requests, 10),
+			newSpacesFormatf(level.Throughput, 10, "%.2f"),
+			newSpacesFormat(roundMicroDuration(level.P50), 10),
+			newSpacesFormat(roundMicroDuration(level.P99), 10),
+			newSpacesFormat(roundMicroDuration(level.Max), 10),
+			newSpacesFormatf(level.Errors, 7, "%.2f").String()+"%",
+			newSpacesFormat(status, 5),
+		)
+	}
+	if result.Interrupted {
+		fmt.Fprintln(w, "Search was interrupted")
+	}
+	if result.Best == nil {
+		fmt.Fprintf(w, "Max sustainable rate: none, %d req/sec breaks SLO\n", result.Options.Start)
+		return
+	}
+	fmt.Fprintf(w, "Max sustainable rate: %d req/sec, throughput: %.2f req/sec\n", result.Best.Rate, result.Best.Throughput)
+}
+
+type JSONSearch struct {
+	Version     int               `json:"version"`
+	Mode        string            `json:"mode"`
+	Window      float64           `json:"window_ms"`
+	SLO         []string          `json:"slo"`
+	MaxRate     int               `json:"max_rate"`
+	Throughput  float64           `json:"max_throughput"`
+	Interrupted bool              `json:"interrupted"`
+	Levels      []JSONSearchLevel `json:"levels"`
+}
+
+type JSONSearchLevel struct {
+	Rate       int             `json:"rate"`
+	Requests   int             `json:"requests"`
+	Throughput float64         `json:"requests_per_sec"`
+	P50        float64         `json:"p50_ms"`
+	P99        float64         `json:"p99_ms"`
+	Max        float64         `json:"max_ms"`
+	Errors     float64         `json:"errors_percent"`
+	Pass       bool            `json:"pass"`
+	Thresholds []JSONThreshold `json:"thresholds"`
+}
+
+//Write search result as JSON, max_rate is 0 if start rate fails
+func PrintJSONSearch(w io.Writer, result *SearchResult) error {
+	report := JSONSearch{
+		Version:     JSONReportVersion,
+		Mode:        result.Options.Mode,
+		Window:      milliseconds(result.Options.Window),
+		Interrupted: result.Interrupted,
+		Levels:      []JSONSearchLevel{},
+	}
+	for _, threshold := range result.Options.SLO {
+		report.SLO = append(report.SLO, threshold.Expr)
+	}
+	if result.Best != nil {
+		report.MaxRate = result.Best.Rate
+		report.Throughput = result.Best.Throughput
+	}
+	for _, level := range result.Levels {
+		item := JSONSearchLevel{
+			Rate:       level.Rate,
+			Requests:   level.Requests,
+			Throughput: level.Throughput,
+			P50:        milliseconds(level.P50),
+			P99:        milliseconds(level.P99),
+			Max:        milliseconds(level.Max),
+			Errors:     level.Errors,
+			Pass:       level.Pass,
+		}
+		for _, threshold := range level.Results {
+			item.Thresholds = append(item.Thresholds, JSONThreshold{
+				Expr:   threshold.Threshold.Expr,
+				Actual: threshold.Actual,
+				Pass:   threshold.Pass,
+			})
+		}
+		report.Levels = append(report.Levels, item)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,99 @@
+package meter
+
+import (
+	"context"
+	"math"
+	nethttp "net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Start target answering 503 above capacity requests per second, bursts of few requests are allowed
+func startCapacityServer(t *testing.T, capacity float64) *url.URL {
+	const burst = 5
+	var lock sync.Mutex
+	tokens, last := float64(burst), time.Now()
+	return startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		lock.Lock()
+		now := time.Now()
+		tokens = math.Min(burst, tokens+now.Sub(last).Seconds()*capacity)
+		last = now
+		allowed := tokens >= 1
+		if allowed {
+			tokens--
+		}
+		lock.Unlock()
+		if !allowed {
+			w.WriteHeader(503)
+		}
+	})
+}
+
+func TestRunSearch(t *testing.T) {
+	slo, _ := ParseThreshold("status:5xx<10%")
+	tests := []struct {
+		options *SearchOptions
+		rates   []int
+		best    int
+	}{
+		//Every rate up to first failed one
+		{&SearchOptions{Mode: "step", Start: 40, Step: 45, Max: 400}, []int{40, 85, 130}, 85},
+		//Bounds, then halves until interval is less than step
+		{&SearchOptions{Mode: "binary", Start: 40, Step: 60, Max: 400}, []int{40, 400, 220, 130, 85}, 85},
+	}
+	for _, test := range tests {
+		test.options.Window = 400 * time.Millisecond
+		test.options.SLO = []*Threshold{slo}
+		config := Config{
+			Url:         startCapacityServer(t, 100),
+			Connections: 2,
+			Threads:     1,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		result, err := RunSearch(ctx, config, test.options)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rates []int
+		for _, level := range result.Levels {
+			rates = append(rates, level.Rate)
+		}
+		if !reflect.DeepEqual(rates, test.rates) || result.Best == nil || result.Best.Rate != test.best || result.Interrupted {
+			t.Errorf("%s: rates %v, best %+v", test.options.Mode, rates, result.Best)
+			continue
+		}
+		//Levels are measured separately, responses of previous rate are dropped
+		for _, level := range result.Levels {
+			expected := float64(level.Rate) * test.options.Window.Seconds()
+			if math.Abs(float64(level.Requests)-expected) > expected/5 {
+				t.Errorf("%s: rate %d, requests %d, errors %.2f%%", test.options.Mode, level.Rate, level.Requests, level.Errors)
+			}
+		}
+	}
+}
+
+func TestSearchOptionsValidate(t *testing.T) {
+	slo, _ := ParseThreshold("p99<100ms")
+	valid := SearchOptions{Mode: "binary", Start: 10, Step: 10, Max: 100, Window: time.Second, SLO: []*Threshold{slo}}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, change := range map[string]func(*SearchOptions){
+		"mode":   func(options *SearchOptions) { options.Mode = "linear" },
+		"slo":    func(options *SearchOptions) { options.SLO = nil },
+		"start":  func(options *SearchOptions) { options.Start = 0 },
+		"step":   func(options *SearchOptions) { options.Step = -1 },
+		"max":    func(options *SearchOptions) { options.Max = 10 },
+		"window": func(options *SearchOptions) { options.Window = 0 },
+	} {
+		options := valid
+		change(&options)
+		if err := options.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package meter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Source struct {
+	lock     sync.Mutex
+	Data     [][]byte
+	Requests []*SourceRequest
+	Index    int
+	//Loaded file name, empty for inline requests
+	File string
+	//Request sequence counter for {{seq}}
+	seq int64
+}
+
+//Request from structured source
+type SourceRequest struct {
+	Method string
+	URL    *url.URL
+	Header map[string][]string
+	Body   []byte
+	//URL as written in source, used for templates
+	RawURI   string
+	Template *RequestTemplate
+}
+
+//JSONL source line or scenario file request
+type SourceEntry struct {
+	Method     string            `json:"method,omitempty" yaml:"method,omitempty"`
+	URL        string            `json:"url,omitempty" yaml:"url,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body       string            `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty" yaml:"body_base64,omitempty"`
+}
+
+func LoadSource(fileName string, delimiter string) (*Source, error) {
+	file, e := ioutil.ReadFile(fileName)
+	if e != nil {
+		return nil, e
+	}
+	buff := bytes.NewBuffer(file)
+	text := buff.String()
+	els := regexp.MustCompile(delimiter).Split(text, -1)
+	newThis := Source{File: fileName}
+	for _, el := range els {
+		if len(el) == 0 {
+			continue
+		}
+		newThis.Data = append(newThis.Data, bytes.NewBufferString(el).Bytes())
+	}
+	return &newThis, nil
+}
+
+//Load JSONL source, one request per line:
+//{"method": "POST", "url": "/path", "headers": {"Name": "value"}, "body": "text"}
+//URL is resolved against base, body_base64 can be used for binary body
+func LoadJSONSource(fileName string, base *url.URL) (*Source, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	newThis := Source{File: fileName}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+		entry := SourceEntry{}
+		if err = json.Unmarshal([]byte(text), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		request, err := entry.toRequest(base)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		newThis.Requests = append(newThis.Requests, request)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &newThis, nil
+}
+
+//Structured source of requests, URLs are resolved against base
+func NewRequestsSource(entries []SourceEntry, base *url.URL) (*Source, error) {
+	newThis := Source{}
+	for i := range entries {
+		request, err := entries[i].toRequest(base)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %v", i+1, err)
+		}
+		newThis.Requests = append(newThis.Requests, request)
+	}
+	return &newThis, nil
+}
+
+func (this *SourceEntry) toRequest(base *url.URL) (*SourceRequest, error) {
+	result := &SourceRequest{
+		Method: strings.ToUpper(this.Method),
+		URL:    base,
+		Header: map[string][]string{},
+		Body:   []byte(this.Body),
+		RawURI: this.URL,
+	}
+	if this.URL != "" {
+		u, err := url.Parse(this.URL)
+		if err != nil {
+			return nil, err
+		}
+		result.URL = base.ResolveReference(u)
+	}
+	for key, value := range this.Headers {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if key == "Content-Length" {
+			continue
+		}
+		result.Header[key] = append(result.Header[key], value)
+	}
+	if this.BodyBase64 != "" {
+		body, err := base64.StdEncoding.DecodeString(this.BodyBase64)
+		if err != nil {
+			return nil, err
+		}
+		result.Body = body
+	}
+	return result, nil
+}
+
+//Check source file is JSONL by extension
+func IsJSONSource(fileName string) bool {
+	return strings.HasSuffix(fileName, ".jsonl") || strings.HasSuffix(fileName, ".ndjson")
+}
+
+//Compile templates of all requests, plain source lines are converted to requests.
+//rawURL is -u value, config headers are merged to request headers
+func (this *Source) CompileTemplates(config *Config, rawURL string, vars *TemplateVars) error {
+	if len(this.Requests) == 0 {
+		for _, line := range this.Data {
+			request := &SourceRequest{Method: config.Method, URL: config.Url, Header: map[string][]string{}}
+			if config.Method == "POST" || config.Method == "PUT" {
+				request.Body = line
+			} else {
+				request.RawURI = string(line)
+			}
+			this.Requests = append(this.Requests, request)
+		}
+		this.Data = nil
+	}
+	if len(this.Requests) == 0 {
+		this.Requests = append(this.Requests, &SourceRequest{Method: config.Method, URL: config.Url, Header: map[string][]string{}})
+	}
+	for _, request := range this.Requests {
+		uri := request.RawURI
+		if uri == "" {
+			uri = rawURL
+		}
+		header := copyHeaders(config.Header)
+		for key, values := range request.Header {
+			header[key] = values
+		}
+		var err error
+		request.Template, err = NewRequestTemplate(rawRequestURI(uri), header, request.Body, vars)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Copy of source for test run, requests are copied without compiled templates, empty source for nil
+func (this *Source) copy() *Source {
+	if this == nil {
+		return &Source{}
+	}
+	result := &Source{Data: this.Data, Index: this.Index, File: this.File}
+	for _, request := range this.Requests {
+		copied := *request
+		copied.Template = nil
+		result.Requests = append(result.Requests, &copied)
+	}
+	return result
+}
+
+//Check plain source lines are URLs for methods without body
+func (this *Source) checkURLs(method string) error {
+	if method == "POST" || method == "PUT" {
+		return nil
+	}
+	for _, line := range this.Data {
+		if _, err := url.Parse(string(line)); err != nil {
+			return fmt.Errorf("URL is broken %s", line)
+		}
+	}
+	return nil
+}
+
+//Next {{seq}} value
+func (this *Source) nextSeq() int64 {
+	return atomic.AddInt64(&this.seq, 1)
+}
+
+func (this *Source) GetNext() *[]byte {
+	if len(this.Data) == 1 {
+		return &this.Data[0]
+	} else if len(this.Data) == 0 {
+		return nil
+	}
+	return &this.Data[this.next(len(this.Data))]
+}
+
+//Next structured request or nil for plain source
+func (this *Source) GetNextRequest() *SourceRequest {
+	if len(this.Requests) == 1 {
+		return this.Requests[0]
+	} else if len(this.Requests) == 0 {
+		return nil
+	}
+	return this.Requests[this.next(len(this.Requests))]
+}
+
+func (this *Source) next(count int) int {
+	//Lock index field and inc
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.Index++
+	if this.Index >= count {
+		this.Index = 0
+	}
+	return this.Index
+}
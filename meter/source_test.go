@@ -0,0 +1,87 @@
+package meter
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+//Write test file to temporary directory
+func writeTestFile(t *testing.T, name string, data string) string {
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadJSONSource(t *testing.T) {
+	base, _ := url.Parse("http://localhost:8080/api/")
+	fileName := writeTestFile(t, "requests.jsonl", `
+{"method": "post", "url": "items?id=1", "headers": {"content-type": "application/json", "Content-Length": "1"}, "body": "{\"a\":1}"}
+
+{"url": "/health"}
+{"method": "PUT", "url": "http://other:9000/upload", "body_base64": "AAEC"}
+`)
+	source, err := LoadJSONSource(fileName, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.File != fileName || len(source.Requests) != 3 {
+		t.Fatalf("file %q, requests %d", source.File, len(source.Requests))
+	}
+	first := source.Requests[0]
+	if first.Method != "POST" || first.URL.String() != "http://localhost:8080/api/items?id=1" || string(first.Body) != `{"a":1}` {
+		t.Errorf("first request %s %s %q", first.Method, first.URL, first.Body)
+	}
+	if got := first.Header["Content-Type"]; len(got) != 1 || got[0] != "application/json" {
+		t.Errorf("Content-Type = %v", got)
+	}
+	if _, ok := first.Header["Content-Length"]; ok {
+		t.Error("Content-Length header must be skipped")
+	}
+	if first.RawURI != "items?id=1" {
+		t.Errorf("RawURI = %q", first.RawURI)
+	}
+	second := source.Requests[1]
+	if second.Method != "" || second.URL.String() != "http://localhost:8080/health" || len(second.Body) != 0 {
+		t.Errorf("second request %q %s %q", second.Method, second.URL, second.Body)
+	}
+	third := source.Requests[2]
+	if third.URL.Host != "other:9000" || string(third.Body) != "\x00\x01\x02" {
+		t.Errorf("third request %s %q", third.URL, third.Body)
+	}
+	//Requests are used round robin
+	var used []*SourceRequest
+	for i := 0; i < 6; i++ {
+		used = append(used, source.GetNextRequest())
+	}
+	if used[0] == used[1] || used[1] == used[2] || used[0] == used[2] || used[0] != used[3] || used[2] != used[5] {
+		t.Error("requests are not used round robin")
+	}
+}
+
+func TestLoadJSONSourceErrors(t *testing.T) {
+	base, _ := url.Parse("http://localhost/")
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"broken json", "{\"url\": \"/a\"}\n{\"url\": "},
+		{"unknown type", `{"url": 1}`},
+		{"broken url", `{"url": "http://[::1"}`},
+		{"broken base64", `{"url": "/a", "body_base64": "***"}`},
+	}
+	for _, test := range tests {
+		if _, err := LoadJSONSource(writeTestFile(t, "requests.jsonl", test.data), base); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+	if _, err := LoadJSONSource("not-exists.jsonl", base); err == nil {
+		t.Error("missing file: expected error")
+	}
+	if !IsJSONSource("a.jsonl") || !IsJSONSource("a.ndjson") || IsJSONSource("a.json") {
+		t.Error("IsJSONSource by extension")
+	}
+}
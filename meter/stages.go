@@ -0,0 +1,238 @@
+package meter
+
+import (
+	"fmt"
+	"github.com/a696385/go-meter/http"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Stage rate is checked with this interval when current rate is 0
+const stageIdleInterval = time.Duration(100) * time.Millisecond
+
+//Load profile stage, rate and connections are linearly changed from previous stage targets
+type Stage struct {
+	Duration time.Duration
+	Rate     int
+	//Target connections, 0 for all -c connections
+	Connections int
+}
+
+type Stages []Stage
+
+//Statistic of one stage
+type StageStats struct {
+	Requests int
+	Readed   int64
+	Writed   int64
+	Latency  *Histogram
+	Errors   ErrorCounters
+}
+
+func (this *StageStats) Merge(other *StageStats) {
+	this.Requests += other.Requests
+	this.Readed += other.Readed
+	this.Writed += other.Writed
+	if other.Latency != nil {
+		this.Latency.Merge(other.Latency)
+	}
+	this.Errors.Add(other.Errors)
+}
+
+//Parse stages like "30s:100rps,2m:1000rps,30s:0" with optional connections "30s:100rps:10c"
+func ParseStages(value string) (Stages, error) {
+	var result Stages
+	for _, item := range SplitList(value) {
+		f := strings.SplitN(item, ":", 3)
+		if len(f) < 2 {
+			return nil, fmt.Errorf("stage %q must be like 30s:100rps or 30s:100rps:10c", item)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(f[0]))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("stage %q duration is broken", item)
+		}
+		rate, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(f[1]), "rps"))
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("stage %q rate is broken", item)
+		}
+		stage := Stage{Duration: duration, Rate: rate}
+		if len(f) == 3 {
+			stage.Connections, err = strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(f[2]), "c"))
+			if err != nil || stage.Connections <= 0 {
+				return nil, fmt.Errorf("stage %q connections are broken", item)
+			}
+		}
+		result = append(result, stage)
+	}
+	return result, nil
+}
+
+//Total duration of all stages
+func (this Stages) Duration() time.Duration {
+	var result time.Duration
+	for _, stage := range this {
+		result += stage.Duration
+	}
+	return result
+}
+
+//Stage index and request rate after elapsed time from test start, first stage starts from startRate
+func (this Stages) RateAt(startRate int, elapsed time.Duration) (int, float64) {
+	prev := float64(startRate)
+	for i, stage := range this {
+		if elapsed < stage.Duration {
+			part := float64(elapsed) / float64(stage.Duration)
+			return i, prev + (float64(stage.Rate)-prev)*part
+		}
+		elapsed -= stage.Duration
+		prev = float64(stage.Rate)
+	}
+	return len(this) - 1, prev
+}
+
+//Any stage has connections target, connections are not ramped otherwise
+func (this Stages) HasConnections() bool {
+	for _, stage := range this {
+		if stage.Connections > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//Max of stages connections targets, stage without target uses all connections
+func (this Stages) MaxConnections(connections int) int {
+	result := 0
+	for _, stage := range this {
+		target := stage.Connections
+		if target == 0 {
+			target = connections
+		}
+		if target > result {
+			result = target
+		}
+	}
+	return result
+}
+
+//Active connections after elapsed time from test start, first stage starts from 1 connection
+func (this Stages) ConnectionsAt(connections int, elapsed time.Duration) int {
+	prev := float64(1)
+	for _, stage := range this {
+		target := float64(stage.Connections)
+		if stage.Connections == 0 {
+			target = float64(connections)
+		}
+		if elapsed < stage.Duration {
+			part := float64(elapsed) / float64(stage.Duration)
+			return int(math.Ceil(prev + (target-prev)*part))
+		}
+		elapsed -= stage.Duration
+		prev = target
+	}
+	return int(prev)
+}
+
+func (this Stage) String() string {
+	if this.Connections > 0 {
+		return fmt.Sprintf("%v:%drps:%dc", this.Duration, this.Rate, this.Connections)
+	}
+	return fmt.Sprintf("%v:%drps", this.Duration, this.Rate)
+}
+
+//Print per stage statistic
+func PrintStages(w io.Writer, report *Report) {
+	source, config := report.Stats, report.Config
+	if len(source.Stages) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Stages: \n     %v %v %v %v %v %v %v\n",
+		newSpacesFormatRightf("Stage", 16, "%s"),
+		newSpacesFormat("Requests", 10),
+		newSpacesFormat("Req/sec", 10),
+		newSpacesFormat("P50", 10),
+		newSpacesFormat("P99", 10),
+		newSpacesFormat("Max", 10),
+		newSpacesFormat("Errors", 8),
+	)
+	for i, stats := range source.Stages {
+		//Stage before stats reset
+		if stats == nil {
+			continue
+		}
+		stage := config.Stages[i]
+		errors := stats.Errors.Total()
+		fmt.Fprintf(w, "     %v %v %v %v %v %v %v\n",
+			newSpacesFormatRightf(stage.String(), 16, "%s"),
+			newSpacesFormat(stats.Requests, 10),
+			newSpacesFormatf(float64(stats.Requests)/stage.Duration.Seconds(), 10, "%.2f"),
+			newSpacesFormat(roundMicroDuration(stats.Latency.Percentile(50)), 10),
+			newSpacesFormat(roundMicroDuration(stats.Latency.Percentile(99)), 10),
+			newSpacesFormat(roundMicroDuration(stats.Latency.Max()), 10),
+			newSpacesFormat(errors, 8),
+		)
+	}
+}
+
+//Stats of stage index, stages without requests since stats reset are nil
+func (this *StatsSource) stage(index int, precision int) *StageStats {
+	for len(this.Stages) <= index {
+		this.Stages = append(this.Stages, nil)
+	}
+	if this.Stages[index] == nil {
+		this.Stages[index] = &StageStats{Latency: NewHistogram(precision)}
+	}
+	return this.Stages[index]
+}
+
+//Stage index of request sent at time, 0 without stages
+func (this *testRun) stageAt(sent time.Time) int {
+	if len(this.Stages) == 0 {
+		return 0
+	}
+	index, _ := this.Stages.RateAt(this.Rate, sent.Sub(this.started))
+	return index
+}
+
+//Stage index of request, open model request is sent at intended time
+func (this *testRun) requestStage(req *http.Request) int {
+	if req.Created.IsZero() {
+		return this.stageAt(time.Now())
+	}
+	return this.stageAt(req.Created)
+}
+
+//Change active connections to current stage target until stop
+func rampConnections(config *testRun, stop chan bool) {
+	ticker := time.NewTicker(stageIdleInterval)
+	defer ticker.Stop()
+	for {
+		config.manager.SetActive(config.Stages.ConnectionsAt(config.Connections, time.Now().Sub(config.started)))
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Current stage number and rate for verbose mode
+func currentStageName(config *testRun) string {
+	elapsed := time.Now().Sub(config.started)
+	index, rate := config.Stages.RateAt(config.Rate, elapsed)
+	if config.Stages.HasConnections() {
+		return fmt.Sprintf("%d %.0frps %dc", index+1, rate, config.Stages.ConnectionsAt(config.Connections, elapsed))
+	}
+	return fmt.Sprintf("%d %.0frps", index+1, rate)
+}
+
+//Verbose mode column, empty without stages
+func stageColumn(config *testRun, value string) string {
+	if len(config.Stages) == 0 {
+		return ""
+	}
+	return " " + newSpacesFormatRightf(value, 16, "%s").String()
+}
@@ -0,0 +1,152 @@
+package meter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseStages(t *testing.T) {
+	stages, err := ParseStages(" 30s:100rps, 2m:1000 ,30s:0,1m:50rps:20c,10s:0:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Stages{
+		{Duration: 30 * time.Second, Rate: 100},
+		{Duration: 2 * time.Minute, Rate: 1000},
+		{Duration: 30 * time.Second, Rate: 0},
+		{Duration: time.Minute, Rate: 50, Connections: 20},
+		{Duration: 10 * time.Second, Rate: 0, Connections: 1},
+	}
+	if len(stages) != len(expected) {
+		t.Fatalf("stages = %v", stages)
+	}
+	for i := range expected {
+		if stages[i] != expected[i] {
+			t.Errorf("stage %d = %+v, want %+v", i, stages[i], expected[i])
+		}
+	}
+	if stages.Duration() != 4*time.Minute+10*time.Second {
+		t.Errorf("duration = %v", stages.Duration())
+	}
+	if stages[0].String() != "30s:100rps" || stages[3].String() != "1m0s:50rps:20c" {
+		t.Errorf("String = %s, %s", stages[0], stages[3])
+	}
+	for _, value := range []string{
+		"30s",
+		"100rps",
+		"0s:100rps",
+		"-1s:100rps",
+		"abc:100rps",
+		"30s:-1rps",
+		"30s:fast",
+		"30s:100rps:0c",
+		"30s:100rps:many",
+	} {
+		if _, err := ParseStages(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+	if stages, err = ParseStages(""); err != nil || len(stages) != 0 {
+		t.Errorf("empty stages: %v, %v", stages, err)
+	}
+}
+
+func TestStagesRateAt(t *testing.T) {
+	stages := Stages{
+		{Duration: 10 * time.Second, Rate: 100},
+		{Duration: 20 * time.Second, Rate: 500},
+		{Duration: 10 * time.Second, Rate: 0},
+	}
+	tests := []struct {
+		start   int
+		elapsed time.Duration
+		index   int
+		rate    float64
+	}{
+		{0, 0, 0, 0},
+		{0, 5 * time.Second, 0, 50},
+		{200, 5 * time.Second, 0, 150},
+		{0, 10 * time.Second, 1, 100},
+		{0, 20 * time.Second, 1, 300},
+		{0, 35 * time.Second, 2, 250},
+		{0, 40 * time.Second, 2, 0},
+		{0, time.Hour, 2, 0},
+		{0, -time.Second, 0, -10},
+	}
+	for _, test := range tests {
+		index, rate := stages.RateAt(test.start, test.elapsed)
+		if index != test.index || math.Abs(rate-test.rate) > 1e-9 {
+			t.Errorf("RateAt(%d, %v) = %d, %g, want %d, %g", test.start, test.elapsed, index, rate, test.index, test.rate)
+		}
+	}
+}
+
+func TestStagesConnectionsAt(t *testing.T) {
+	plain := Stages{{Duration: 10 * time.Second, Rate: 100}}
+	if plain.HasConnections() || plain.MaxConnections(8) != 8 {
+		t.Error("stages without connections must use all connections")
+	}
+	stages := Stages{
+		{Duration: 10 * time.Second, Rate: 100, Connections: 11},
+		{Duration: 10 * time.Second, Rate: 100},
+		{Duration: 10 * time.Second, Rate: 0, Connections: 2},
+	}
+	if !stages.HasConnections() {
+		t.Error("HasConnections = false")
+	}
+	if got := stages.MaxConnections(5); got != 11 {
+		t.Errorf("MaxConnections(5) = %d", got)
+	}
+	if got := stages.MaxConnections(20); got != 20 {
+		t.Errorf("MaxConnections(20) = %d", got)
+	}
+	tests := []struct {
+		elapsed time.Duration
+		result  int
+	}{
+		{0, 1},
+		{5 * time.Second, 6},
+		{10 * time.Second, 11},
+		{15 * time.Second, 8},
+		{20 * time.Second, 5},
+		{25 * time.Second, 4},
+		{time.Hour, 2},
+	}
+	for _, test := range tests {
+		if got := stages.ConnectionsAt(5, test.elapsed); got != test.result {
+			t.Errorf("ConnectionsAt(5, %v) = %d, want %d", test.elapsed, got, test.result)
+		}
+	}
+}
+
+func TestStatsStage(t *testing.T) {
+	source := &StatsSource{Latency: NewHistogram(DefaultHistogramPrecision)}
+	source.stage(2, DefaultHistogramPrecision).Requests++
+	if len(source.Stages) != 3 || source.Stages[0] != nil || source.Stages[2].Requests != 1 {
+		t.Fatalf("stages = %v", source.Stages)
+	}
+	other := &StatsSource{Latency: NewHistogram(DefaultHistogramPrecision)}
+	other.stage(0, DefaultHistogramPrecision).Requests = 5
+	other.Merge(source)
+	if len(other.Stages) != 3 || other.Stages[0].Requests != 5 || other.Stages[1] != nil || other.Stages[2].Requests != 1 {
+		t.Errorf("merged stages = %v", other.Stages)
+	}
+}
+
+func TestStagedThreadShortStage(t *testing.T) {
+	config := Config{
+		Url:         startTestServer(t, nil),
+		Connections: 4,
+		Threads:     1,
+		Stages:      Stages{{Duration: 10 * time.Millisecond, Rate: 80}, {Duration: 480 * time.Millisecond, Rate: 80}},
+	}
+	//Budget of first quarter is sent on start, stage is not idle until first tick
+	stats := runTest(t, config, 5*time.Second).Stats
+	if stats.Requests < 36 || stats.Requests > 42 {
+		t.Errorf("requests %d, want about 40", stats.Requests)
+	}
+	if len(stats.Stages) != 2 || stats.Stages[0] == nil || stats.Stages[0].Requests == 0 {
+		t.Errorf("stages %v", stats.Stages)
+	}
+}
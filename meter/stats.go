@@ -0,0 +1,636 @@
+package meter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//Percentiles in final report
+var reportPercentiles = []float64{50, 75, 90, 99, 99.9, 99.99}
+
+//Live error and send counters of test, updated atomically
+type counters struct {
+	ConnectionErrors int32
+	ReadErrors       int32
+	WriteErrors      int32
+	TimeoutErrors    int32
+	Reconnects       int32
+	LateRequests     int32
+	MissedRequests   int32
+	//Requests without response after drain
+	AbandonedRequests int32
+}
+
+//Format with space prefix
+type SpacesFormat struct {
+	data   interface{}
+	len    int
+	right  bool
+	format string
+}
+
+func (this SpacesFormat) String() string {
+	if this.format == "" {
+		this.format = "%v"
+	}
+	s := fmt.Sprintf(this.format, this.data)
+	for {
+		if len(s) >= this.len {
+			break
+		}
+		if this.right {
+			s += " "
+		} else {
+			s = " " + s
+		}
+	}
+	return s
+}
+
+//Statistic data
+type StatsSource struct {
+	Readed        int64
+	Writed        int64
+	Requests      int
+	Skiped        int
+	Latency       *Histogram
+	Phases        PhaseStats
+	Codes         map[int]int
+	Connect       DurationStats
+	Handshake     DurationStats
+	ReadErrors    int
+	WriteErrors   int
+	TimeoutErrors int
+	//Connection errors of all connects
+	ConnectionErrors int
+	Reconnects       int
+	//Open model sends
+	LateRequests   int
+	MissedRequests int
+	//Requests without response after drain timeout
+	AbandonedRequests int
+	Work              time.Duration
+	Stages            []*StageStats
+	Groups            []*GroupStats
+}
+
+//Min/avg/max of latency component
+type DurationStats struct {
+	Count int
+	Min   time.Duration
+	Max   time.Duration
+	Sum   time.Duration
+}
+
+func (this *DurationStats) Add(d time.Duration) {
+	this.Count++
+	this.Sum += d
+	if this.Count == 1 || this.Min > d {
+		this.Min = d
+	}
+	if this.Max < d {
+		this.Max = d
+	}
+}
+
+func (this *DurationStats) Merge(other *DurationStats) {
+	if other.Count == 0 {
+		return
+	}
+	if this.Count == 0 || this.Min > other.Min {
+		this.Min = other.Min
+	}
+	if this.Max < other.Max {
+		this.Max = other.Max
+	}
+	this.Count += other.Count
+	this.Sum += other.Sum
+}
+
+func (this *DurationStats) Avg() time.Duration {
+	if this.Count == 0 {
+		return 0
+	}
+	return this.Sum / time.Duration(this.Count)
+}
+
+//Snapshot of global error counters
+type ErrorCounters struct {
+	Connection int
+	Read       int
+	Write      int
+	Timeout    int
+}
+
+func (this *counters) errors() ErrorCounters {
+	return ErrorCounters{
+		Connection: int(atomic.LoadInt32(&this.ConnectionErrors)),
+		Read:       int(atomic.LoadInt32(&this.ReadErrors)),
+		Write:      int(atomic.LoadInt32(&this.WriteErrors)),
+		Timeout:    int(atomic.LoadInt32(&this.TimeoutErrors)),
+	}
+}
+
+//Store counters to final statistic
+func (this *counters) store(stats *StatsSource) {
+	errors := this.errors()
+	stats.ConnectionErrors = errors.Connection
+	stats.ReadErrors = errors.Read
+	stats.WriteErrors = errors.Write
+	stats.TimeoutErrors = errors.Timeout
+	stats.Reconnects = int(atomic.LoadInt32(&this.Reconnects))
+	stats.LateRequests = int(atomic.LoadInt32(&this.LateRequests))
+	stats.MissedRequests = int(atomic.LoadInt32(&this.MissedRequests))
+	stats.AbandonedRequests = int(atomic.LoadInt32(&this.AbandonedRequests))
+}
+
+func (this *counters) reset() {
+	atomic.StoreInt32(&this.ConnectionErrors, 0)
+	atomic.StoreInt32(&this.ReadErrors, 0)
+	atomic.StoreInt32(&this.WriteErrors, 0)
+	atomic.StoreInt32(&this.TimeoutErrors, 0)
+	atomic.StoreInt32(&this.Reconnects, 0)
+	atomic.StoreInt32(&this.LateRequests, 0)
+	atomic.StoreInt32(&this.MissedRequests, 0)
+	atomic.StoreInt32(&this.AbandonedRequests, 0)
+}
+
+//Final errors of test
+func (this *StatsSource) Errors() ErrorCounters {
+	return ErrorCounters{
+		Connection: this.ConnectionErrors,
+		Read:       this.ReadErrors,
+		Write:      this.WriteErrors,
+		Timeout:    this.TimeoutErrors,
+	}
+}
+
+func (this ErrorCounters) Sub(other ErrorCounters) ErrorCounters {
+	return ErrorCounters{
+		Connection: this.Connection - other.Connection,
+		Read:       this.Read - other.Read,
+		Write:      this.Write - other.Write,
+		Timeout:    this.Timeout - other.Timeout,
+	}
+}
+
+func (this *ErrorCounters) Add(other ErrorCounters) {
+	this.Connection += other.Connection
+	this.Read += other.Read
+	this.Write += other.Write
+	this.Timeout += other.Timeout
+}
+
+//Count of all errors
+func (this ErrorCounters) Total() int {
+	return this.Connection + this.Read + this.Write + this.Timeout
+}
+
+//Statistic data for verbose mode and time series
+type StatsSourcePerSecond struct {
+	Readed   int64
+	Writed   int64
+	Requests int
+	Skiped   int
+	Latency  *Histogram
+	Codes    map[int]int
+	Errors   ErrorCounters
+}
+
+func newStatsSourcePerSecond(latency *Histogram) StatsSourcePerSecond {
+	latency.Reset()
+	return StatsSourcePerSecond{
+		Latency: latency,
+		Codes:   make(map[int]int),
+	}
+}
+
+//Stat aggregator
+func startStatsAggregator(config *testRun) {
+	allowStore := true
+	allowStoreTime := time.After(config.ExcludeSeconds)
+	source := config.stats
+	if config.ExcludeSeconds.Seconds() > 0 {
+		allowStore = false
+	}
+
+	verboseTimer := time.NewTicker(time.Duration(1) * time.Second)
+	if config.Verbose {
+		printPerSecondHeader(config)
+	} else if config.CSV == nil && config.OnSecond == nil && config.Metrics == nil {
+		verboseTimer.Stop()
+	}
+
+	perSecond := newStatsSourcePerSecond(NewHistogram(config.Precision))
+	lastErrors := config.counters.errors()
+
+	//Add response to stats
+	record := func(res *RequestStats) {
+		//Add counters
+		source.Requests++
+		perSecond.Requests++
+		perSecond.Readed += res.NetIn
+		perSecond.Writed += res.NetOut
+		source.Readed += res.NetIn
+		source.Writed += res.NetOut
+		//Add HTTP code counter
+		source.Codes[res.ResponseCode]++
+		perSecond.Codes[res.ResponseCode]++
+		if config.Metrics != nil {
+			config.Metrics.Add(res, allowStore)
+		}
+		var group *GroupStats
+		if len(source.Groups) > 0 {
+			group = source.Groups[res.Group]
+			group.Requests++
+			group.Readed += res.NetIn
+			group.Writed += res.NetOut
+			group.Codes[res.ResponseCode]++
+		}
+		var stage *StageStats
+		if len(config.Stages) > 0 {
+			stage = source.stage(res.Stage, config.Precision)
+			stage.Requests++
+			stage.Readed += res.NetIn
+			stage.Writed += res.NetOut
+		}
+		if !allowStore {
+			perSecond.Skiped++
+			source.Skiped++
+			return
+		}
+		//Add duration to histograms
+		source.Latency.Record(res.Duration)
+		source.Phases.Record(res)
+		perSecond.Latency.Record(res.Duration)
+		if stage != nil {
+			stage.Latency.Record(res.Duration)
+		}
+		if group != nil {
+			group.Latency.Record(res.Duration)
+		}
+		//Connect and TLS handshake time of new connections
+		if res.ConnectDuration > 0 {
+			source.Connect.Add(res.ConnectDuration)
+		}
+		if res.HandshakeDuration > 0 {
+			source.Handshake.Add(res.HandshakeDuration)
+		}
+	}
+
+	start := time.Now()
+	//Work time is counted from last reset
+	statsStart := start
+	for {
+		select {
+		//Verbose mode and time series timer
+		case <-verboseTimer.C:
+			second := roundToSecondDuration(time.Now().Sub(start))
+			errors := config.counters.errors()
+			perSecond.Errors = errors.Sub(lastErrors)
+			lastErrors = errors
+			printPerSecond(config, second, source.Requests, &perSecond)
+			if config.Metrics != nil {
+				config.Metrics.AddErrors(perSecond.Errors)
+			}
+			if config.OnSecond != nil {
+				config.OnSecond(second, &perSecond)
+			}
+			//Clear data
+			perSecond = newStatsSourcePerSecond(perSecond.Latency)
+		//Allow store avg data timer
+		case <-allowStoreTime:
+			allowStore = true
+		//Request response
+		case res := <-config.requestStats:
+			record(res)
+		//Print stats snapshot, test is not stopped
+		case <-config.Snapshot:
+			source.Work = millisecondDuration(time.Now().Sub(statsStart))
+			config.storeCounters(source)
+			fmt.Fprintf(config.Log, "Snapshot at %v:\n", millisecondDuration(time.Now().Sub(start)))
+			report := &Report{Config: config.Config, Stats: source, Thresholds: CheckThresholds(source, config.Thresholds)}
+			PrintStats(config.Log, report)
+			PrintThresholds(config.Log, report.Thresholds)
+		//Clear stats, per second errors are counted from reset
+		case <-config.Reset:
+			config.resetStats()
+			source = config.stats
+			statsStart = time.Now()
+			lastErrors = config.counters.errors()
+			fmt.Fprintf(config.Log, "Stats reset at %v\n", millisecondDuration(time.Now().Sub(start)))
+		//Exit event
+		case <-config.statsQuit:
+			//Responses received before exit are in channel
+			for len(config.requestStats) > 0 {
+				record(<-config.requestStats)
+			}
+			//Strore work time
+			source.Work = millisecondDuration(time.Now().Sub(statsStart))
+			config.storeCounters(source)
+			if config.Metrics != nil {
+				config.Metrics.AddErrors(source.Errors().Sub(lastErrors))
+			}
+			if config.Verbose {
+				printPerSecondFooter(config)
+			}
+			//Confirm exit
+			config.statsQuited <- true
+			return
+		}
+	}
+}
+
+//Clear statistic and error counters before new test
+func (this *testRun) resetStats() {
+	this.stats = &StatsSource{
+		Codes:   make(map[int]int),
+		Latency: NewHistogram(this.Precision),
+		Phases:  NewPhaseStats(this.Precision),
+	}
+	for _, group := range this.Groups {
+		this.stats.Groups = append(this.stats.Groups, &GroupStats{
+			Name:    group.Name,
+			Weight:  group.Weight,
+			Latency: NewHistogram(this.Precision),
+			Codes:   make(map[int]int),
+		})
+	}
+	this.counters.reset()
+	for i := range this.groupCounters {
+		this.groupCounters[i].reset()
+	}
+	for i := range this.stageCounters {
+		this.stageCounters[i].reset()
+	}
+}
+
+//Store test, groups and stages error counters to statistic
+func (this *testRun) storeCounters(stats *StatsSource) {
+	this.counters.store(stats)
+	for i, group := range stats.Groups {
+		group.Errors = this.groupCounters[i].errors()
+	}
+	for i := range this.stageCounters {
+		errors := this.stageCounters[i].errors()
+		if errors.Total() > 0 || i < len(stats.Stages) && stats.Stages[i] != nil {
+			stats.stage(i, this.Precision).Errors = errors
+		}
+	}
+}
+
+func printPerSecondHeader(config *testRun) {
+	fmt.Fprintf(config.Log, "%s %s %s %s %s %s %s %s%s\n",
+		newSpacesFormatRightf("Second", 10, "%s"),
+		newSpacesFormatRightf("Total", 10, "%s"),
+		newSpacesFormatRightf("Req/sec", 10, "%s"),
+		newSpacesFormatRightf("Avg/sec", 10, "%s"),
+		newSpacesFormatRightf("P50/sec", 10, "%s"),
+		newSpacesFormatRightf("P99/sec", 10, "%s"),
+		newSpacesFormatRightf("In/sec", 10, "%s"),
+		newSpacesFormatRightf("Out/sec", 10, "%s"),
+		stageColumn(config, "Stage"),
+	)
+}
+
+//Write time series row and print verbose stats of second
+func printPerSecond(config *testRun, second time.Duration, total int, perSecond *StatsSourcePerSecond) {
+	if config.CSV != nil {
+		if err := config.CSV.Write(second, total, perSecond); err != nil {
+			fmt.Fprintf(config.Log, "ERROR: Can not write CSV %v\n", err)
+			config.CSV = nil
+		}
+	}
+	if perSecond.Requests-perSecond.Skiped > 0 && config.Verbose {
+		//Print stats
+		fmt.Fprintf(config.Log, "%s %s %s %s %s %s %s %s%s\n",
+			newSpacesFormatRightf(second, 10, "%v"),
+			newSpacesFormatRightf(total, 10, "%d"),
+			newSpacesFormatRightf(perSecond.Requests, 10, "%d"),
+			newSpacesFormatRightf(roundMicroDuration(perSecond.Latency.Mean()), 10, "%v"),
+			newSpacesFormatRightf(roundMicroDuration(perSecond.Latency.Percentile(50)), 10, "%v"),
+			newSpacesFormatRightf(roundMicroDuration(perSecond.Latency.Percentile(99)), 10, "%v"),
+			newSpacesFormatRightf(Bites(perSecond.Readed), 10, "%s"),
+			newSpacesFormatRightf(Bites(perSecond.Writed), 10, "%s"),
+			stageColumn(config, currentStageName(config)),
+		)
+	}
+}
+
+//Print verbose mode footer
+func printPerSecondFooter(config *testRun) {
+	s := ""
+	for {
+		if len(s) >= 87+len(stageColumn(config, "")) {
+			break
+		}
+		s += "-"
+	}
+	fmt.Fprintln(config.Log, s)
+}
+
+//Add other agent statistic, work time is max of both
+func (this *StatsSource) Merge(other *StatsSource) {
+	this.Readed += other.Readed
+	this.Writed += other.Writed
+	this.Requests += other.Requests
+	this.Skiped += other.Skiped
+	if other.Latency != nil {
+		this.Latency.Merge(other.Latency)
+	}
+	this.Phases.Merge(&other.Phases)
+	for code, count := range other.Codes {
+		this.Codes[code] += count
+	}
+	this.Connect.Merge(&other.Connect)
+	this.Handshake.Merge(&other.Handshake)
+	this.ReadErrors += other.ReadErrors
+	this.WriteErrors += other.WriteErrors
+	this.TimeoutErrors += other.TimeoutErrors
+	this.ConnectionErrors += other.ConnectionErrors
+	this.Reconnects += other.Reconnects
+	this.LateRequests += other.LateRequests
+	this.MissedRequests += other.MissedRequests
+	this.AbandonedRequests += other.AbandonedRequests
+	if other.Work > this.Work {
+		this.Work = other.Work
+	}
+	for i, stage := range other.Stages {
+		if stage != nil {
+			this.stage(i, this.Latency.Precision()).Merge(stage)
+		}
+	}
+	for i, group := range other.Groups {
+		if i == len(this.Groups) {
+			this.Groups = append(this.Groups, &GroupStats{Name: group.Name, Weight: group.Weight, Latency: NewHistogram(this.Latency.Precision()), Codes: make(map[int]int)})
+		}
+		this.Groups[i].Merge(group)
+	}
+}
+
+func (this *StatsSourcePerSecond) Merge(other *StatsSourcePerSecond) {
+	this.Readed += other.Readed
+	this.Writed += other.Writed
+	this.Requests += other.Requests
+	this.Skiped += other.Skiped
+	if other.Latency != nil {
+		this.Latency.Merge(other.Latency)
+	}
+	for code, count := range other.Codes {
+		this.Codes[code] += count
+	}
+	this.Errors.Add(other.Errors)
+}
+
+func newSpacesFormat(data interface{}, len int) SpacesFormat {
+	return SpacesFormat{data, len, false, "%v"}
+}
+func newSpacesFormatf(data interface{}, len int, format string) SpacesFormat {
+	return SpacesFormat{data, len, false, format}
+}
+
+func newSpacesFormatRightf(data interface{}, len int, format string) SpacesFormat {
+	return SpacesFormat{data, len, true, format}
+}
+
+//Print all statistic
+func PrintStats(w io.Writer, report *Report) {
+	source, config := report.Stats, report.Config
+	//Print latency stats, traffic stats
+	fmt.Fprintf(w, "Stats:      %v %v %v\n", newSpacesFormat("Min", 9), newSpacesFormat("Avg", 9), newSpacesFormat("Max", 9))
+	fmt.Fprintf(w, "  Latency   %v %v %v\n", newSpacesFormat(roundMicroDuration(source.Latency.Min()), 9), newSpacesFormat(roundMicroDuration(source.Latency.Mean()), 9), newSpacesFormat(roundMicroDuration(source.Latency.Max()), 9))
+	printDurationStats(w, "Connect", &source.Connect)
+	printDurationStats(w, "Handshake", &source.Handshake)
+	fmt.Fprintf(w, "  %d requests in %v", source.Requests, source.Work)
+	//Errors
+	if source.ReadErrors > 0 || source.WriteErrors > 0 && source.Requests > 0 {
+		fmt.Fprintf(w, ", errors: read %d - %.2f%%, write %d - %.2f%%", source.ReadErrors, getPercent(source.ReadErrors, source.Requests), source.WriteErrors, getPercent(source.WriteErrors, source.Requests))
+	}
+	if source.TimeoutErrors > 0 {
+		fmt.Fprintf(w, ", timeouts: %d - %.2f%%", source.TimeoutErrors, getPercentOrZero(source.TimeoutErrors, source.Requests))
+	}
+	//Traffic
+	fmt.Fprintf(w, ", net: in %s, out %s\n", Bytes(source.Readed), Bytes(source.Writed))
+	//Connection errors
+	if source.ConnectionErrors > 0 {
+		fmt.Fprintf(w, "  connection errors: %d\n", source.ConnectionErrors)
+	}
+	if source.Reconnects > 0 {
+		fmt.Fprintf(w, "  reconnects: %d\n", source.Reconnects)
+	}
+	if source.AbandonedRequests > 0 {
+		fmt.Fprintf(w, "  abandoned in flight: %d\n", source.AbandonedRequests)
+	}
+	if config.Rate > 0 {
+		fmt.Fprintf(w, "  late sends: %d, missed sends: %d\n", source.LateRequests, source.MissedRequests)
+	}
+	//Print details info
+	if source.Requests > 0 {
+		//Sort HTTP Code and print
+		fmt.Fprintln(w, "HTTP Codes: ")
+		keys := make([]int, len(source.Codes))
+		i := 0
+		for key, _ := range source.Codes {
+			keys[i] = key
+			i++
+		}
+		sort.Ints(keys)
+		for _, key := range keys {
+			value := source.Codes[key]
+			fmt.Fprintf(w, "     %d    %v%%\n", key, newSpacesFormatf(getPercent(value, source.Requests), 9, "%.2f"))
+		}
+
+		//Latency percentiles
+		if source.Latency.Count() > 0 {
+			fmt.Fprintln(w, "Latency: ")
+			for _, percentile := range reportPercentiles {
+				fmt.Fprintf(w, "     %v    %v\n", newSpacesFormatf(percentile, 6, "%g%%"), newSpacesFormat(roundMicroDuration(source.Latency.Percentile(percentile)), 9))
+			}
+		}
+	}
+	PrintPhases(w, source)
+	PrintStages(w, report)
+	PrintGroups(w, report)
+
+	//Print speed stats
+	if int(source.Work.Seconds()) > 0 {
+		fmt.Fprintf(w, "Requests: %.2f/sec\n", float64(source.Requests)/source.Work.Seconds())
+		fmt.Fprintf(w, "Net In: %s/sec\n", Bites(source.Readed/int64(source.Work.Seconds())))
+		fmt.Fprintf(w, "Net Out: %s/sec\n", Bites(source.Writed/int64(source.Work.Seconds())))
+		fmt.Fprintf(w, "Transfer: %s/sec\n", Bytes((source.Writed+source.Readed)/int64(source.Work.Seconds())))
+	}
+}
+
+func printDurationStats(w io.Writer, name string, stats *DurationStats) {
+	if stats.Count == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %v %v %v %v\n", newSpacesFormatRightf(name, 9, "%s"), newSpacesFormat(roundMicroDuration(stats.Min), 9), newSpacesFormat(roundMicroDuration(stats.Avg()), 9), newSpacesFormat(roundMicroDuration(stats.Max), 9))
+}
+
+func logn(n, b float64) float64 { return math.Log(n) / math.Log(b) }
+
+func humanateBytes(s int64, base float64, sizes []string) string {
+	if s < 10 {
+		return fmt.Sprintf("%dB", s)
+	}
+	e := math.Floor(logn(float64(s), base))
+	suffix := sizes[int(e)]
+	val := float64(s) / math.Pow(base, math.Floor(e))
+	f := "%.0f"
+	if val < 10 {
+		f = "%.1f"
+	}
+
+	return fmt.Sprintf(f+"%s", val, suffix)
+}
+
+func Bytes(s int64) string {
+	sizes := []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+	return humanateBytes(int64(s), 1024, sizes)
+}
+
+func Bites(s int64) string {
+	sizes := []string{"Bit", "KBit", "MBit", "GBit", "TBit", "PBit", "EBit"}
+	return humanateBytes(int64(s*8), 1024, sizes)
+}
+
+func getPercent(c int, max int) float64 {
+	return float64(c) * 100 / float64(max)
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return time.Duration(RoundFloat(d.Seconds()*1000, 0)) * time.Millisecond
+}
+
+func roundMicroDuration(d time.Duration) time.Duration {
+	return time.Duration(RoundFloat(d.Seconds()*1000000, 0)) * time.Microsecond
+}
+
+//Duration truncated to milliseconds
+func millisecondDuration(d time.Duration) time.Duration {
+	return time.Duration(d.Seconds()*1000) * time.Millisecond
+}
+
+func roundToSecondDuration(d time.Duration) time.Duration {
+	return time.Duration(RoundFloat(d.Seconds(), 0)) * time.Second
+}
+
+func RoundFloat(x float64, prec int) float64 {
+	var rounder float64
+	pow := math.Pow(10, float64(prec))
+	intermed := x * pow
+
+	if intermed < 0.0 {
+		intermed -= 0.5
+	} else {
+		intermed += 0.5
+	}
+	rounder = float64(int64(intermed))
+
+	return rounder / float64(pow)
+}
@@ -0,0 +1,61 @@
+package meter
+
+import (
+	"bytes"
+	"context"
+	nethttp "net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunSnapshotReset(t *testing.T) {
+	var failing, requests int32 = 1, 0
+	snapshot, reset := make(chan bool), make(chan bool)
+	log := &bytes.Buffer{}
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			//Target is failing until reset with error codes and closed connections
+			if atomic.AddInt32(&requests, 1)%2 == 0 && atomic.LoadInt32(&failing) == 1 {
+				conn, _, _ := w.(nethttp.Hijacker).Hijack()
+				conn.Close()
+			} else if atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(503)
+			}
+		}),
+		Connections: 1,
+		Threads:     1,
+		MRQ:         40,
+		Duration:    time.Second,
+		Log:         log,
+		Snapshot:    snapshot,
+		Reset:       reset,
+	}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		snapshot <- true
+		atomic.StoreInt32(&failing, 0)
+		time.Sleep(100 * time.Millisecond)
+		reset <- true
+	}()
+	report, err := Run(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Snapshot does not stop test, reset clears stats and error counters
+	stats := report.Stats
+	if output := log.String(); !strings.Contains(output, "Snapshot at") || !strings.Contains(output, "Stats reset at") {
+		t.Errorf("log:\n%s", output)
+	}
+	if read, write, timeout := testErrors(stats); read != 0 || write != 0 || timeout != 0 || stats.ConnectionErrors != 0 {
+		t.Errorf("read %d, write %d, timeout %d, connection %d", read, write, timeout, stats.ConnectionErrors)
+	}
+	received := int(atomic.LoadInt32(&requests))
+	if stats.Requests == 0 || stats.Codes[200] != stats.Requests || len(stats.Codes) != 1 || stats.Requests >= received {
+		t.Errorf("requests %d of %d, codes %v", stats.Requests, received, stats.Codes)
+	}
+	if stats.Work > 700*time.Millisecond {
+		t.Errorf("work %v", stats.Work)
+	}
+}
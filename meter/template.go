@@ -0,0 +1,305 @@
+package meter
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const templateLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+//Values of one request, all templates of request use the same sequence number and vars row
+type TemplateContext struct {
+	Seq        int64
+	Thread     int
+	Connection int
+	Row        []string
+}
+
+//Template part appends value to buffer
+type templatePart func(buf []byte, ctx *TemplateContext) []byte
+
+//Precompiled text template with {{generator args}} placeholders:
+//seq, randInt MIN MAX, randString LEN, uuid, timestamp, timestampMs, thread, connection, var COLUMN
+type Template struct {
+	text  string
+	parts []templatePart
+	//Text without placeholders
+	static []byte
+}
+
+//Compiled request URI, headers and body
+type RequestTemplate struct {
+	URI    *Template
+	Header map[string][]*Template
+	Body   *Template
+}
+
+//Values from CSV file with header line for {{var COLUMN}}, rows are used round robin
+type TemplateVars struct {
+	columns map[string]int
+	rows    [][]string
+	index   uint64
+}
+
+func LoadTemplateVars(fileName string) (*TemplateVars, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return NewTemplateVars(records)
+}
+
+//Create vars from CSV records, first record is header line
+func NewTemplateVars(records [][]string) (*TemplateVars, error) {
+	if len(records) < 2 {
+		return nil, errors.New("vars file must have header line and at least one row")
+	}
+	result := &TemplateVars{
+		columns: map[string]int{},
+		rows:    records[1:],
+	}
+	for i, name := range records[0] {
+		result.columns[strings.TrimSpace(name)] = i
+	}
+	return result, nil
+}
+
+//CSV records with header line
+func (this *TemplateVars) Records() [][]string {
+	if this == nil {
+		return nil
+	}
+	header := []string{}
+	for name, i := range this.columns {
+		for len(header) <= i {
+			header = append(header, "")
+		}
+		header[i] = name
+	}
+	return append([][]string{header}, this.rows...)
+}
+
+func (this *TemplateVars) NextRow() []string {
+	if this == nil {
+		return nil
+	}
+	index := atomic.AddUint64(&this.index, 1) - 1
+	return this.rows[index%uint64(len(this.rows))]
+}
+
+//Create context of new request
+func NewTemplateContext(seq int64, vars *TemplateVars, thread int, connection int) *TemplateContext {
+	return &TemplateContext{
+		Seq:        seq,
+		Thread:     thread,
+		Connection: connection,
+		Row:        vars.NextRow(),
+	}
+}
+
+func CompileTemplate(text string, vars *TemplateVars) (*Template, error) {
+	result := &Template{text: text}
+	for len(text) > 0 {
+		start := strings.Index(text, "{{")
+		if start == -1 {
+			result.parts = append(result.parts, literalPart(text))
+			break
+		}
+		end := strings.Index(text[start:], "}}")
+		if end == -1 {
+			return nil, fmt.Errorf("template %q: missing }}", result.text)
+		}
+		if start > 0 {
+			result.parts = append(result.parts, literalPart(text[:start]))
+		}
+		part, err := compileGenerator(strings.Fields(text[start+2:start+end]), vars)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %v", result.text, err)
+		}
+		result.parts = append(result.parts, part)
+		text = text[start+end+2:]
+	}
+	if !strings.Contains(result.text, "{{") {
+		result.static = []byte(result.text)
+	}
+	return result, nil
+}
+
+func literalPart(text string) templatePart {
+	return func(buf []byte, ctx *TemplateContext) []byte {
+		return append(buf, text...)
+	}
+}
+
+func compileGenerator(fields []string, vars *TemplateVars) (templatePart, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("empty placeholder")
+	}
+	name, args := fields[0], fields[1:]
+	ints, err := parseTemplateInts(args)
+	if err != nil && name != "var" {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	switch {
+	case name == "seq" && len(args) == 0:
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			return strconv.AppendInt(buf, ctx.Seq, 10)
+		}, nil
+	case name == "randInt" && len(args) == 2 && ints[0] <= ints[1]:
+		min, n := ints[0], ints[1]-ints[0]+1
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			return strconv.AppendInt(buf, min+rand.Int63n(n), 10)
+		}, nil
+	case name == "randString" && len(args) == 1 && ints[0] > 0:
+		n := int(ints[0])
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			for i := 0; i < n; i++ {
+				buf = append(buf, templateLetters[rand.Intn(len(templateLetters))])
+			}
+			return buf
+		}, nil
+	case name == "uuid" && len(args) == 0:
+		return appendUUID, nil
+	case name == "timestamp" && len(args) == 0:
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			return strconv.AppendInt(buf, time.Now().Unix(), 10)
+		}, nil
+	case name == "timestampMs" && len(args) == 0:
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			return strconv.AppendInt(buf, time.Now().UnixNano()/int64(time.Millisecond), 10)
+		}, nil
+	case name == "thread" && len(args) == 0:
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			return strconv.AppendInt(buf, int64(ctx.Thread), 10)
+		}, nil
+	case name == "connection" && len(args) == 0:
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			return strconv.AppendInt(buf, int64(ctx.Connection), 10)
+		}, nil
+	case name == "var" && len(args) == 1:
+		if vars == nil {
+			return nil, errors.New("var requires -vars file")
+		}
+		column, ok := vars.columns[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown var %s", args[0])
+		}
+		return func(buf []byte, ctx *TemplateContext) []byte {
+			if column < len(ctx.Row) {
+				buf = append(buf, ctx.Row[column]...)
+			}
+			return buf
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown placeholder {{%s}}", strings.Join(fields, " "))
+}
+
+func parseTemplateInts(args []string) ([]int64, error) {
+	result := make([]int64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+//Random version 4 UUID
+func appendUUID(buf []byte, ctx *TemplateContext) []byte {
+	var b [16]byte
+	hi, lo := rand.Uint64(), rand.Uint64()
+	for i := 0; i < 8; i++ {
+		b[i] = byte(hi >> uint(56-8*i))
+		b[8+i] = byte(lo >> uint(56-8*i))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return append(buf, s[:]...)
+}
+
+func (this *Template) Execute(buf []byte, ctx *TemplateContext) []byte {
+	for _, part := range this.parts {
+		buf = part(buf, ctx)
+	}
+	return buf
+}
+
+//Rendered text, static text is not copied
+func (this *Template) Bytes(ctx *TemplateContext) []byte {
+	if this.static != nil {
+		return this.static
+	}
+	return this.Execute(nil, ctx)
+}
+
+func (this *Template) String(ctx *TemplateContext) string {
+	if this.static != nil {
+		return this.text
+	}
+	return string(this.Execute(nil, ctx))
+}
+
+func NewRequestTemplate(uri string, header map[string][]string, body []byte, vars *TemplateVars) (*RequestTemplate, error) {
+	result := &RequestTemplate{Header: map[string][]*Template{}}
+	var err error
+	if result.URI, err = CompileTemplate(uri, vars); err != nil {
+		return nil, err
+	}
+	if result.Body, err = CompileTemplate(string(body), vars); err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			t, err := CompileTemplate(value, vars)
+			if err != nil {
+				return nil, err
+			}
+			result.Header[key] = append(result.Header[key], t)
+		}
+	}
+	return result, nil
+}
+
+//Request URI from raw URL without escaping, template placeholders are kept as is
+func rawRequestURI(rawURL string) string {
+	if i := strings.Index(rawURL, "://"); i > -1 {
+		rawURL = rawURL[i+3:]
+		if j := strings.IndexAny(rawURL, "/?"); j > -1 {
+			rawURL = rawURL[j:]
+		} else {
+			rawURL = "/"
+		}
+	}
+	if i := strings.Index(rawURL, "#"); i > -1 {
+		rawURL = rawURL[:i]
+	}
+	if !strings.HasPrefix(rawURL, "/") {
+		rawURL = "/" + rawURL
+	}
+	return rawURL
+}
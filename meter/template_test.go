@@ -0,0 +1,126 @@
+package meter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompileTemplate(t *testing.T) {
+	vars, err := NewTemplateVars([][]string{{"user", " token "}, {"alice", "t1"}, {"bob", "t2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &TemplateContext{Seq: 42, Thread: 3, Connection: 7, Row: vars.NextRow()}
+	tests := []struct {
+		text   string
+		result string
+	}{
+		{"/static/path", "/static/path"},
+		{"", ""},
+		{"/items/{{seq}}", "/items/42"},
+		{"{{thread}}-{{connection}}", "3-7"},
+		{"{{ seq }}{{seq}}", "4242"},
+		{"user={{var user}}&token={{var token}}", "user=alice&token=t1"},
+		{"{{randInt 5 5}}", "5"},
+		{"{ not placeholder }", "{ not placeholder }"},
+	}
+	for _, test := range tests {
+		template, err := CompileTemplate(test.text, vars)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.text, err)
+			continue
+		}
+		if got := template.String(ctx); got != test.result {
+			t.Errorf("%q: String = %q, want %q", test.text, got, test.result)
+		}
+		if got := string(template.Bytes(ctx)); got != test.result {
+			t.Errorf("%q: Bytes = %q, want %q", test.text, got, test.result)
+		}
+	}
+	//Next request uses next vars row
+	template, _ := CompileTemplate("{{var user}}", vars)
+	if got := template.String(NewTemplateContext(1, vars, 0, 0)); got != "bob" {
+		t.Errorf("second row = %q", got)
+	}
+}
+
+func TestCompileTemplateGenerators(t *testing.T) {
+	tests := []struct {
+		text    string
+		pattern string
+	}{
+		{"{{randInt -10 10}}", `^-?\d+$`},
+		{"{{randString 12}}", `^[a-zA-Z0-9]{12}$`},
+		{"{{uuid}}", `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{"{{timestamp}}", `^\d{10}$`},
+		{"{{timestampMs}}", `^\d{13}$`},
+	}
+	ctx := &TemplateContext{}
+	for _, test := range tests {
+		template, err := CompileTemplate(test.text, nil)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.text, err)
+			continue
+		}
+		re := regexp.MustCompile(test.pattern)
+		for i := 0; i < 100; i++ {
+			if got := template.String(ctx); !re.MatchString(got) {
+				t.Errorf("%q: %q does not match %s", test.text, got, test.pattern)
+				break
+			}
+		}
+	}
+	template, _ := CompileTemplate("{{randInt -10 10}}", nil)
+	for i := 0; i < 1000; i++ {
+		if v, _ := strconv.Atoi(template.String(ctx)); v < -10 || v > 10 {
+			t.Fatalf("randInt value %d out of range", v)
+		}
+	}
+}
+
+func TestCompileTemplateErrors(t *testing.T) {
+	vars, _ := NewTemplateVars([][]string{{"user"}, {"alice"}})
+	for _, text := range []string{
+		"/items/{{seq",
+		"{{}}",
+		"{{unknown}}",
+		"{{seq 1}}",
+		"{{randInt 1}}",
+		"{{randInt 10 1}}",
+		"{{randInt a b}}",
+		"{{randString 0}}",
+		"{{uuid 4}}",
+		"{{var}}",
+		"{{var missing}}",
+	} {
+		if _, err := CompileTemplate(text, vars); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+	if _, err := CompileTemplate("{{var user}}", nil); err == nil || !strings.Contains(err.Error(), "-vars") {
+		t.Errorf("var without vars file: %v", err)
+	}
+	if _, err := NewTemplateVars([][]string{{"user"}}); err == nil {
+		t.Error("vars without rows: expected error")
+	}
+}
+
+func TestRawRequestURI(t *testing.T) {
+	tests := []struct {
+		raw    string
+		result string
+	}{
+		{"http://host/items/{{seq}}?q={{randString 4}}", "/items/{{seq}}?q={{randString 4}}"},
+		{"http://host:8080", "/"},
+		{"http://host?a=1", "/?a=1"},
+		{"https://host/path#fragment", "/path"},
+		{"items/1", "/items/1"},
+	}
+	for _, test := range tests {
+		if got := rawRequestURI(test.raw); got != test.result {
+			t.Errorf("rawRequestURI(%q) = %q, want %q", test.raw, got, test.result)
+		}
+	}
+}
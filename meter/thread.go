@@ -0,0 +1,290 @@
+package meter
+
+import (
+	"github.com/a696385/go-meter/http"
+	"math"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+//Open model request sent later than intended time is counted as late
+const lateSendTolerance = time.Duration(10) * time.Millisecond
+
+func newThread(config *testRun, id int) {
+	if config.Rate > 0 {
+		openModelThread(config, id)
+		return
+	}
+	if len(config.Stages) > 0 {
+		stagedThread(config, id)
+		return
+	}
+	timerAllow := time.NewTicker(time.Duration(250) * time.Millisecond)
+	allow := int32(config.MRQ / 4 / config.Threads)
+	if config.MRQ == -1 {
+		allow = -1
+	} else if allow <= 0 {
+		allow = 1
+		timerAllow.Stop()
+		timerAllow.C = nil
+	}
+	currentAllow := allow
+	for {
+		select {
+		//Set allow requests per time timer
+		case <-timerAllow.C:
+			currentAllow = allow
+		//Get free tcp connection
+		case connection := <-config.manager.C:
+			if config.MRQ != -1 {
+				currentAllow--
+			}
+			//Return connection to pool if allowed request is 0
+			if currentAllow > 0 || config.MRQ == -1 {
+				if !connection.Take() {
+					continue
+				}
+				//Create request object
+				req, group := newRequest(config, id, connection)
+				//Send request if we connected
+				go connection.Exec(req, group)
+			} else {
+				connection.Return()
+			}
+		//Wait exit event
+		case <-config.workerQuit:
+			//Complete exit
+			config.workerQuited <- true
+			return
+		}
+	}
+}
+
+//Closed model with stages, allowed requests per 250ms follow current stage rate
+func stagedThread(config *testRun, id int) {
+	timerAllow := time.NewTicker(time.Duration(250) * time.Millisecond)
+	defer timerAllow.Stop()
+	//Fractional part of allowed requests is kept for next tick
+	budget := float64(0)
+	refill := func(elapsed time.Duration) {
+		_, rate := config.Stages.RateAt(config.Rate, elapsed)
+		budget += rate / 4 / float64(config.Threads)
+		//Do not accumulate more than one second of requests while connections are busy
+		if limit := math.Max(rate/float64(config.Threads), 1); budget > limit {
+			budget = limit
+		}
+	}
+	//Budget of first quarter is allowed on start, stage rate is taken at quarter end
+	refill(time.Duration(250) * time.Millisecond)
+	pool := config.manager.C
+	if budget < 1 {
+		pool = nil
+	}
+	for {
+		select {
+		case <-timerAllow.C:
+			refill(time.Now().Sub(config.started))
+		//Get free tcp connection, pool is not selected while nothing is allowed
+		case connection := <-pool:
+			//Connection is out of pool if stage connections are decreased
+			if !connection.Take() {
+				continue
+			}
+			budget--
+			req, group := newRequest(config, id, connection)
+			go connection.Exec(req, group)
+		case <-config.workerQuit:
+			config.workerQuited <- true
+			return
+		}
+		if budget >= 1 {
+			pool = config.manager.C
+		} else {
+			pool = nil
+		}
+	}
+}
+
+//Send requests at constant arrival rate independent of responses.
+//Thread id sends every Threads-th slot, latency is measured from slot time.
+//Slot is missed if no connection is free at slot time, arrivals never wait for connections.
+//With stages slot interval follows current stage rate
+func openModelThread(config *testRun, id int) {
+	interval := time.Second * time.Duration(config.Threads) / time.Duration(config.Rate)
+	intended := config.started.Add(time.Second * time.Duration(id) / time.Duration(config.Rate))
+	timer := time.NewTimer(intended.Sub(time.Now()))
+	defer timer.Stop()
+	slots := float64(0)
+	for {
+		//Wait slot time
+		select {
+		case <-timer.C:
+		case <-config.workerQuit:
+			//Count passed slots not handled yet
+			if late := time.Now().Sub(intended); late >= 0 {
+				atomic.AddInt32(&config.counters.MissedRequests, int32(late/interval+1))
+			}
+			config.workerQuited <- true
+			return
+		}
+		send := true
+		if len(config.Stages) > 0 {
+			_, rate := config.Stages.RateAt(config.Rate, intended.Sub(config.started))
+			interval = stageIdleInterval
+			if rate*stageIdleInterval.Seconds() >= float64(config.Threads) {
+				interval = time.Duration(float64(time.Second) * float64(config.Threads) / rate)
+			} else {
+				//Slow rate, slot parts are accumulated every idle interval
+				slots += rate * stageIdleInterval.Seconds() / float64(config.Threads)
+				if send = slots >= 1; send {
+					slots--
+				}
+			}
+		}
+		if send {
+			sendSlot(config, id, intended)
+		}
+		intended = intended.Add(interval)
+		timer.Reset(intended.Sub(time.Now()))
+	}
+}
+
+//Send request of slot on free connection, slot is counted as missed if all connections are busy
+func sendSlot(config *testRun, id int, intended time.Time) {
+	select {
+	case connection := <-config.manager.C:
+		if !connection.Take() {
+			return
+		}
+		if time.Now().Sub(intended) > lateSendTolerance {
+			atomic.AddInt32(&config.counters.LateRequests, 1)
+		}
+		req, group := newRequest(config, id, connection)
+		req.Created = intended
+		go connection.Exec(req, group)
+	default:
+		atomic.AddInt32(&config.counters.MissedRequests, 1)
+	}
+}
+
+//Create request from structured or plain source of next group, returns request and group index
+func newRequest(config *testRun, thread int, connection *poolConnection) (*http.Request, int) {
+	group, source := 0, config.Source
+	if len(config.Groups) > 0 {
+		group = config.nextGroup()
+		source = config.Groups[group].Source
+	}
+	if request := source.GetNextRequest(); request != nil {
+		if request.Template != nil {
+			return getTemplateRequest(config, request, NewTemplateContext(config.Source.nextSeq(), config.Vars, thread, connection.id)), group
+		}
+		return getSourceRequest(config, request), group
+	}
+	return getRequest(config.Method, config.Url, config.host, config.Header, source.GetNext()), group
+}
+
+//Create request from structured source, source headers override config headers.
+//Request is sent to config host, source URL host is used as Host header
+func getSourceRequest(config *testRun, request *SourceRequest) *http.Request {
+	header := copyHeaders(config.Header)
+	for key, values := range request.Header {
+		header[key] = values
+	}
+	method := request.Method
+	if method == "" {
+		method = config.Method
+	}
+	host := config.host
+	if request.URL.Host != config.Url.Host {
+		host = request.URL.Host
+	}
+	if h := header["Host"]; len(h) > 0 {
+		host = h[0]
+		delete(header, "Host")
+	}
+	return &http.Request{
+		Method:        method,
+		URL:           request.URL,
+		Header:        header,
+		Body:          request.Body,
+		ContentLength: int64(len(request.Body)),
+		Host:          host,
+	}
+}
+
+//Create request from compiled templates, headers are already merged with config headers
+func getTemplateRequest(config *testRun, request *SourceRequest, ctx *TemplateContext) *http.Request {
+	template := request.Template
+	header := make(map[string][]string, len(template.Header))
+	for key, values := range template.Header {
+		result := make([]string, len(values))
+		for i, value := range values {
+			result[i] = value.String(ctx)
+		}
+		header[key] = result
+	}
+	method := request.Method
+	if method == "" {
+		method = config.Method
+	}
+	host := config.host
+	if request.URL.Host != config.Url.Host {
+		host = request.URL.Host
+	}
+	if h := header["Host"]; len(h) > 0 {
+		host = h[0]
+		delete(header, "Host")
+	}
+	body := template.Body.Bytes(ctx)
+	return &http.Request{
+		Method:        method,
+		URL:           request.URL,
+		RequestURI:    template.URI.String(ctx),
+		Header:        header,
+		Body:          body,
+		ContentLength: int64(len(body)),
+		Host:          host,
+	}
+}
+
+func getRequest(method string, URL *url.URL, host string, headers map[string][]string, body *[]byte) *http.Request {
+	header := copyHeaders(headers)
+
+	if method == "POST" || method == "PUT" {
+		//Body is empty without source or for group without source
+		var data []byte
+		if body != nil {
+			data = *body
+		}
+		return &http.Request{
+			Method:        method,
+			URL:           URL,
+			Header:        header,
+			Body:          data,
+			ContentLength: int64(len(data)),
+			Host:          host,
+		}
+	} else {
+		//Use source data as URL request or original URL
+		var (
+			r   *url.URL
+			err error
+		)
+		if body != nil {
+			//Source URLs are checked before test
+			r, err = url.Parse(string(*body))
+			if err != nil {
+				r = URL
+			}
+		} else {
+			r = URL
+		}
+		return &http.Request{
+			Method: method,
+			URL:    r,
+			Header: header,
+			Host:   host,
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package meter
+
+import (
+	nethttp "net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpenModelSlowServer(t *testing.T) {
+	release := make(chan bool)
+	config := Config{
+		Url: startTestServer(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-release:
+			}
+		}),
+		Connections:  2,
+		Threads:      2,
+		Rate:         400,
+		Duration:     time.Second,
+		DrainTimeout: 100 * time.Millisecond,
+	}
+	stats := runTest(t, config, 5*time.Second).Stats
+	close(release)
+	//Server answers 40 requests per second, queues of busy connections are full
+	if stats.Requests < 20 || stats.Requests > 60 || stats.MissedRequests < 50 {
+		t.Errorf("requests %d, missed %d", stats.Requests, stats.MissedRequests)
+	}
+	//Every arrival is sent or missed, sent requests are answered or abandoned
+	sent := stats.Requests + stats.ReadErrors + stats.WriteErrors + stats.TimeoutErrors + stats.AbandonedRequests
+	if total := sent + stats.MissedRequests; total < 398 || total > 402 || stats.AbandonedRequests == 0 {
+		t.Errorf("sent %d, missed %d, abandoned %d", sent, stats.MissedRequests, stats.AbandonedRequests)
+	}
+	if stats.LateRequests > sent {
+		t.Errorf("late %d, sent %d", stats.LateRequests, sent)
+	}
+}
+
+func TestSendSlot(t *testing.T) {
+	config := &Config{Url: startTestServer(t, nil), Connections: 1, Threads: 1, Rate: 10}
+	run, err := config.prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = run.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer run.manager.Close()
+	counts := func() (int32, int32) {
+		return atomic.LoadInt32(&run.counters.LateRequests), atomic.LoadInt32(&run.counters.MissedRequests)
+	}
+	//Slot is late if free connection is taken after tolerance
+	sendSlot(run, 0, time.Now().Add(-10*lateSendTolerance))
+	if late, missed := counts(); late != 1 || missed != 0 {
+		t.Errorf("late slot: late %d, missed %d", late, missed)
+	}
+	//Slot is missed if all connections are busy
+	connection := <-run.manager.C
+	sendSlot(run, 0, time.Now())
+	if late, missed := counts(); late != 1 || missed != 1 {
+		t.Errorf("busy slot: late %d, missed %d", late, missed)
+	}
+	connection.Return()
+	sendSlot(run, 0, time.Now())
+	if late, missed := counts(); late != 1 || missed != 1 {
+		t.Errorf("slot in time: late %d, missed %d", late, missed)
+	}
+}
@@ -0,0 +1,240 @@
+package meter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Threshold metric value kinds
+const (
+	thresholdDuration = iota
+	thresholdPercent
+	thresholdNumber
+)
+
+var thresholdRegexp = regexp.MustCompile(`^\s*([a-zA-Z0-9.:]+)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+//SLO assertion like "p99<200ms", "errors<0.1%", "rps>5000", "status:5xx<1%"
+type Threshold struct {
+	Expr   string
+	Metric string
+	Op     string
+	//Milliseconds for durations, percents or plain number
+	Value float64
+	kind  int
+}
+
+//Result of threshold check
+type ThresholdResult struct {
+	Threshold *Threshold
+	Actual    float64
+	Pass      bool
+}
+
+//Repeatable -assert flag
+type ThresholdsFlag []*Threshold
+
+func (this *ThresholdsFlag) String() string {
+	exprs := make([]string, len(*this))
+	for i, threshold := range *this {
+		exprs[i] = threshold.Expr
+	}
+	return strings.Join(exprs, ", ")
+}
+
+func (this *ThresholdsFlag) Set(value string) error {
+	threshold, err := ParseThreshold(value)
+	if err != nil {
+		return err
+	}
+	*this = append(*this, threshold)
+	return nil
+}
+
+func ParseThreshold(expr string) (*Threshold, error) {
+	m := thresholdRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("threshold %q must be like p99<200ms", expr)
+	}
+	result := &Threshold{
+		Expr:   strings.TrimSpace(expr),
+		Metric: strings.ToLower(m[1]),
+		Op:     m[2],
+	}
+	var err error
+	result.kind, err = thresholdKind(result.Metric)
+	if err != nil {
+		return nil, err
+	}
+	value := m[3]
+	switch result.kind {
+	case thresholdDuration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("threshold %q value must be duration like 200ms", expr)
+		}
+		result.Value = milliseconds(d)
+	case thresholdPercent:
+		result.Value, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	default:
+		result.Value, err = strconv.ParseFloat(value, 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("threshold %q value is not a number", expr)
+	}
+	return result, nil
+}
+
+func thresholdKind(metric string) (int, error) {
+	switch {
+	case metric == "min" || metric == "mean" || metric == "avg" || metric == "max":
+		return thresholdDuration, nil
+	case strings.HasPrefix(metric, "p"):
+		if _, err := parsePercentile(metric); err != nil {
+			return 0, err
+		}
+		return thresholdDuration, nil
+	case metric == "errors":
+		return thresholdPercent, nil
+	case strings.HasPrefix(metric, "status:"):
+		if _, _, err := parseStatusMetric(metric); err != nil {
+			return 0, err
+		}
+		return thresholdPercent, nil
+	case metric == "rps" || metric == "requests":
+		return thresholdNumber, nil
+	}
+	return 0, fmt.Errorf("unknown threshold metric %s", metric)
+}
+
+//Parse "p99.9" to 99.9
+func parsePercentile(metric string) (float64, error) {
+	percentile, err := strconv.ParseFloat(metric[1:], 64)
+	if err != nil || percentile <= 0 || percentile > 100 {
+		return 0, fmt.Errorf("unknown threshold metric %s", metric)
+	}
+	return percentile, nil
+}
+
+//Parse "status:5xx" to code class 5 or "status:503" to code 503
+func parseStatusMetric(metric string) (code int, class bool, err error) {
+	value := strings.TrimPrefix(metric, "status:")
+	if len(value) == 3 && strings.HasSuffix(value, "xx") {
+		code, err = strconv.Atoi(value[:1])
+		class = true
+	} else {
+		code, err = strconv.Atoi(value)
+	}
+	if err != nil {
+		err = errors.New("status threshold must be like status:5xx or status:503")
+	}
+	return
+}
+
+//Value of metric in statistic
+func (this *Threshold) Actual(source *StatsSource) float64 {
+	switch this.kind {
+	case thresholdDuration:
+		switch this.Metric {
+		case "min":
+			return milliseconds(source.Latency.Min())
+		case "mean", "avg":
+			return milliseconds(source.Latency.Mean())
+		case "max":
+			return milliseconds(source.Latency.Max())
+		}
+		percentile, _ := parsePercentile(this.Metric)
+		return milliseconds(source.Latency.Percentile(percentile))
+	case thresholdPercent:
+		if this.Metric == "errors" {
+			count := source.Errors().Total()
+			return getPercentOrZero(count, source.Requests+count)
+		}
+		code, class, _ := parseStatusMetric(this.Metric)
+		count := 0
+		for key, value := range source.Codes {
+			if key == code || (class && key/100 == code) {
+				count += value
+			}
+		}
+		return getPercentOrZero(count, source.Requests)
+	}
+	if this.Metric == "requests" {
+		return float64(source.Requests)
+	}
+	if source.Work.Seconds() > 0 {
+		return float64(source.Requests) / source.Work.Seconds()
+	}
+	return 0
+}
+
+func (this *Threshold) Check(source *StatsSource) *ThresholdResult {
+	actual := this.Actual(source)
+	result := &ThresholdResult{Threshold: this, Actual: actual}
+	switch this.Op {
+	case "<":
+		result.Pass = actual < this.Value
+	case "<=":
+		result.Pass = actual <= this.Value
+	case ">":
+		result.Pass = actual > this.Value
+	case ">=":
+		result.Pass = actual >= this.Value
+	}
+	return result
+}
+
+//Format actual value in threshold units
+func (this *ThresholdResult) String() string {
+	switch this.Threshold.kind {
+	case thresholdDuration:
+		return roundMicroDuration(time.Duration(this.Actual * float64(time.Millisecond))).String()
+	case thresholdPercent:
+		return fmt.Sprintf("%.2f%%", this.Actual)
+	}
+	return strconv.FormatFloat(this.Actual, 'f', 2, 64)
+}
+
+func CheckThresholds(source *StatsSource, thresholds []*Threshold) []*ThresholdResult {
+	results := make([]*ThresholdResult, len(thresholds))
+	for i, threshold := range thresholds {
+		results[i] = threshold.Check(source)
+	}
+	return results
+}
+
+//Print pass/fail table, returns false if any threshold failed
+func PrintThresholds(w io.Writer, results []*ThresholdResult) bool {
+	if len(results) == 0 {
+		return true
+	}
+	maxLen := 0
+	for _, result := range results {
+		if len(result.Threshold.Expr) > maxLen {
+			maxLen = len(result.Threshold.Expr)
+		}
+	}
+	passed := true
+	fmt.Fprintln(w, "Thresholds: ")
+	for _, result := range results {
+		status := "PASS"
+		if !result.Pass {
+			status = "FAIL"
+			passed = false
+		}
+		fmt.Fprintf(w, "     %v    %v    %s\n", newSpacesFormatRightf(result.Threshold.Expr, maxLen, "%s"), newSpacesFormat(result.String(), 12), status)
+	}
+	return passed
+}
+
+func getPercentOrZero(c int, max int) float64 {
+	if max == 0 {
+		return 0
+	}
+	return getPercent(c, max)
+}
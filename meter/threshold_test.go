@@ -0,0 +1,113 @@
+package meter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		expr   string
+		metric string
+		op     string
+		value  float64
+		kind   int
+	}{
+		{"p99<200ms", "p99", "<", 200, thresholdDuration},
+		{" P99.9 <= 1.5s ", "p99.9", "<=", 1500, thresholdDuration},
+		{"mean<500us", "mean", "<", 0.5, thresholdDuration},
+		{"max>=1m", "max", ">=", 60000, thresholdDuration},
+		{"errors<0.1%", "errors", "<", 0.1, thresholdPercent},
+		{"errors<1", "errors", "<", 1, thresholdPercent},
+		{"status:5xx<1%", "status:5xx", "<", 1, thresholdPercent},
+		{"status:503<=0.5%", "status:503", "<=", 0.5, thresholdPercent},
+		{"rps>5000", "rps", ">", 5000, thresholdNumber},
+		{"requests>=100", "requests", ">=", 100, thresholdNumber},
+	}
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expr, err)
+			continue
+		}
+		if threshold.Metric != test.metric || threshold.Op != test.op || threshold.Value != test.value || threshold.kind != test.kind {
+			t.Errorf("%q: parsed %s %s %g kind %d", test.expr, threshold.Metric, threshold.Op, threshold.Value, threshold.kind)
+		}
+	}
+	for _, expr := range []string{
+		"",
+		"p99",
+		"p99=200ms",
+		"p99<200",
+		"p0<1ms",
+		"p101<1ms",
+		"pxx<1ms",
+		"latency<1ms",
+		"errors<abc%",
+		"status:5x<1%",
+		"status:abc<1%",
+		"rps>fast",
+		"p99 < 200 ms",
+	} {
+		if _, err := ParseThreshold(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestThresholdCheck(t *testing.T) {
+	source := &StatsSource{
+		Requests:      1000,
+		Latency:       NewHistogram(DefaultHistogramPrecision),
+		Codes:         map[int]int{200: 980, 404: 5, 503: 10, 504: 5},
+		ReadErrors:    3,
+		TimeoutErrors: 2,
+		Work:          10 * time.Second,
+	}
+	for i := 1; i <= 1000; i++ {
+		source.Latency.Record(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		expr   string
+		actual float64
+		pass   bool
+	}{
+		{"p50<600ms", 500, true},
+		{"p99<900ms", 990, false},
+		{"p99<=991ms", 990, true},
+		{"max>999ms", 1000, true},
+		{"min>=2ms", 1, false},
+		{"avg<1s", 500.5, true},
+		{"status:5xx<1%", 1.5, false},
+		{"status:5xx<2%", 1.5, true},
+		{"status:404<=0.5%", 0.5, true},
+		{"status:2xx>99%", 98, false},
+		{"rps>=100", 100, true},
+		{"rps>100", 100, false},
+		{"requests>999", 1000, true},
+	}
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := threshold.Check(source)
+		//Durations are compared with histogram precision
+		if math.Abs(result.Actual-test.actual) > test.actual/1000 || result.Pass != test.pass {
+			t.Errorf("%s: actual %g pass %v, want %g %v", test.expr, result.Actual, result.Pass, test.actual, test.pass)
+		}
+	}
+	//Errors are percent of all attempts
+	threshold, _ := ParseThreshold("errors<0.4%")
+	result := threshold.Check(source)
+	if want := float64(5) * 100 / 1005; result.Actual != want || result.Pass {
+		t.Errorf("errors: actual %g pass %v, want %g false", result.Actual, result.Pass, want)
+	}
+	//Empty test
+	empty := &StatsSource{Latency: NewHistogram(DefaultHistogramPrecision), Codes: map[int]int{}}
+	results := CheckThresholds(empty, []*Threshold{threshold})
+	if len(results) != 1 || results[0].Actual != 0 || !results[0].Pass {
+		t.Errorf("empty test: %+v", results[0])
+	}
+}
@@ -0,0 +1,136 @@
+package meter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+//TLS options from command line
+type TLSOptions struct {
+	ServerName string
+	Insecure   bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	//PEM contents, used instead of files when set
+	CA         []byte
+	Cert       []byte
+	Key        []byte
+	MinVersion string
+	MaxVersion string
+	Ciphers    string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//Build client TLS config, host is used as SNI when ServerName is empty
+func NewTLSConfig(options *TLSOptions, host string) (*tls.Config, error) {
+	result := &tls.Config{
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.Insecure,
+	}
+	if result.ServerName == "" {
+		result.ServerName = host
+	}
+	options, err := options.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(options.CA) > 0 {
+		result.RootCAs = x509.NewCertPool()
+		if !result.RootCAs.AppendCertsFromPEM(options.CA) {
+			return nil, errors.New("no certificates in TLS CA")
+		}
+	}
+	if len(options.Cert) > 0 || len(options.Key) > 0 {
+		if len(options.Cert) == 0 || len(options.Key) == 0 {
+			return nil, errors.New("client certificate and key must be set together")
+		}
+		cert, err := tls.X509KeyPair(options.Cert, options.Key)
+		if err != nil {
+			return nil, err
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	if result.MinVersion, err = parseTLSVersion(options.MinVersion); err != nil {
+		return nil, err
+	}
+	if result.MaxVersion, err = parseTLSVersion(options.MaxVersion); err != nil {
+		return nil, err
+	}
+	if options.Ciphers != "" {
+		if result.CipherSuites, err = parseCiphers(options.Ciphers); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+//Copy of options with PEM contents read from files, file names are cleared
+func (this *TLSOptions) loadFiles() (*TLSOptions, error) {
+	result := *this
+	files := []struct {
+		name     *string
+		contents *[]byte
+	}{
+		{&result.CAFile, &result.CA},
+		{&result.CertFile, &result.Cert},
+		{&result.KeyFile, &result.Key},
+	}
+	for _, file := range files {
+		if *file.name == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(*file.name)
+		if err != nil {
+			return nil, err
+		}
+		*file.contents = data
+		*file.name = ""
+	}
+	return &result, nil
+}
+
+//Parse version like "1.2", empty string is default version
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %s", version)
+}
+
+//Parse comma separated cipher suite names
+func parseCiphers(names string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	var result []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
@@ -0,0 +1,187 @@
+package meter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Test certificate with PEM encoded certificate and key
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+//Create certificate signed by parent, self-signed CA if parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+//Start https server with certificate, client certificates of CA are required if clientCA is set
+func startTLSServer(t *testing.T, cert *testCert, clientCA *testCert) *url.URL {
+	server := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	if clientCA != nil {
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLS.ClientCAs = x509.NewCertPool()
+		server.TLS.ClientCAs.AddCert(clientCA.cert)
+	}
+	//Server errors of rejected handshakes are not test output
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	return u
+}
+
+func TestRunTLS(t *testing.T) {
+	ca := newTestCert(t, "test CA", nil)
+	serverCert := newTestCert(t, "127.0.0.1", ca)
+	clientCert := newTestCert(t, "client", ca)
+	caFile := writeTestFile(t, "ca.pem", string(ca.certPEM))
+	certFile := writeTestFile(t, "client.pem", string(clientCert.certPEM))
+	keyFile := writeTestFile(t, "client.key", string(clientCert.keyPEM))
+
+	selfSigned := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	selfSigned.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	selfSigned.StartTLS()
+	defer selfSigned.Close()
+	selfSignedURL, _ := url.Parse(selfSigned.URL)
+
+	tests := []struct {
+		name    string
+		url     *url.URL
+		options *TLSOptions
+		ok      bool
+	}{
+		{"CA bundle", startTLSServer(t, serverCert, nil), &TLSOptions{CAFile: caFile}, true},
+		{"unknown CA", startTLSServer(t, serverCert, nil), &TLSOptions{}, false},
+		{"insecure self-signed", selfSignedURL, &TLSOptions{Insecure: true}, true},
+		{"self-signed", selfSignedURL, &TLSOptions{}, false},
+		{"client certificate", startTLSServer(t, serverCert, ca), &TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, true},
+		{"client certificate PEM", startTLSServer(t, serverCert, ca), &TLSOptions{CA: ca.certPEM, Cert: clientCert.certPEM, Key: clientCert.keyPEM}, true},
+		{"no client certificate", startTLSServer(t, serverCert, ca), &TLSOptions{CAFile: caFile}, false},
+		{"TLS 1.2 only", startTLSServer(t, serverCert, nil), &TLSOptions{CAFile: caFile, MinVersion: "1.2", MaxVersion: "1.2",
+			Ciphers: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, true},
+	}
+	for _, test := range tests {
+		config := Config{
+			Url:         test.url,
+			TLS:         test.options,
+			Connections: 2,
+			Threads:     1,
+			MRQ:         -1,
+			Requests:    10,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		report, err := Run(ctx, config)
+		cancel()
+		if !test.ok {
+			//Rejected handshake fails first request with mTLS, dial otherwise
+			if err == nil && report.Stats.Requests == 10 {
+				t.Errorf("%s: expected TLS error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		stats := report.Stats
+		if stats.Requests != 10 || stats.Codes[200] != 10 {
+			t.Errorf("%s: requests %d, codes %v", test.name, stats.Requests, stats.Codes)
+		}
+		//Handshake is measured separately from TCP connect
+		if stats.Connect.Count != 2 || stats.Handshake.Count != 2 || stats.Handshake.Min <= 0 {
+			t.Errorf("%s: connect %+v, handshake %+v", test.name, stats.Connect, stats.Handshake)
+		}
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	result, err := NewTLSConfig(&TLSOptions{MinVersion: "1.2", MaxVersion: "1.3", Ciphers: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_RC4_128_SHA"}, "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ServerName != "service" || result.MinVersion != tls.VersionTLS12 || result.MaxVersion != tls.VersionTLS13 ||
+		len(result.CipherSuites) != 2 || result.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("config %+v", result)
+	}
+	if result, _ = NewTLSConfig(&TLSOptions{ServerName: "sni", Insecure: true}, "service"); result.ServerName != "sni" || !result.InsecureSkipVerify {
+		t.Errorf("SNI %s, insecure %v", result.ServerName, result.InsecureSkipVerify)
+	}
+	cert := newTestCert(t, "client", nil)
+	for _, test := range []struct {
+		options *TLSOptions
+		err     string
+	}{
+		{&TLSOptions{MinVersion: "1.4"}, "unknown TLS version"},
+		{&TLSOptions{MaxVersion: "tls1.2"}, "unknown TLS version"},
+		{&TLSOptions{Ciphers: "TLS_FAST"}, "unknown cipher suite"},
+		{&TLSOptions{CA: []byte("not PEM")}, "no certificates"},
+		{&TLSOptions{CAFile: "not-exists.pem"}, "not-exists.pem"},
+		{&TLSOptions{Cert: cert.certPEM}, "must be set together"},
+		{&TLSOptions{Cert: cert.certPEM, Key: newTestCert(t, "other", nil).keyPEM}, "private key does not match"},
+	} {
+		if _, err := NewTLSConfig(test.options, "service"); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%+v: error %v, want %q", test.options, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/a696385/go-meter/meter"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Test scenario file, values are applied to flags not set in command line
+type Scenario struct {
+	URL         string `json:"url,omitempty" yaml:"url,omitempty"`
+	Method      string `json:"method,omitempty" yaml:"method,omitempty"`
+	Connections int    `json:"connections,omitempty" yaml:"connections,omitempty"`
+	Threads     int    `json:"threads,omitempty" yaml:"threads,omitempty"`
+	MRQ         int    `json:"mrq,omitempty" yaml:"mrq,omitempty"`
+	Rate        int    `json:"rate,omitempty" yaml:"rate,omitempty"`
+	Duration    string `json:"duration,omitempty" yaml:"duration,omitempty"`
+	//Total requests budget, -n
+	RequestsLimit  int             `json:"requests_limit,omitempty" yaml:"requests_limit,omitempty"`
+	PerConnection  int             `json:"per_connection,omitempty" yaml:"per_connection,omitempty"`
+	Stages         []ScenarioStage `json:"stages,omitempty" yaml:"stages,omitempty"`
+	Reconnect      bool            `json:"reconnect,omitempty" yaml:"reconnect,omitempty"`
+	ConnectTimeout string          `json:"connect_timeout,omitempty" yaml:"connect_timeout,omitempty"`
+	WriteTimeout   string          `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty"`
+	TTFBTimeout    string          `json:"ttfb_timeout,omitempty" yaml:"ttfb_timeout,omitempty"`
+	Timeout        string          `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	DrainTimeout   string          `json:"drain_timeout,omitempty" yaml:"drain_timeout,omitempty"`
+	Verbose        bool            `json:"verbose,omitempty" yaml:"verbose,omitempty"`
+	Precision      int             `json:"precision,omitempty" yaml:"precision,omitempty"`
+	Exclude        string          `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	//Headers override headers file, -H overrides both
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	HeadersFile string            `json:"headers_file,omitempty" yaml:"headers_file,omitempty"`
+	//Source file or requests list, -s overrides requests
+	Source     string              `json:"source,omitempty" yaml:"source,omitempty"`
+	HAR        *ScenarioHAR        `json:"har,omitempty" yaml:"har,omitempty"`
+	Requests   []meter.SourceEntry `json:"requests,omitempty" yaml:"requests,omitempty"`
+	Template   bool                `json:"template,omitempty" yaml:"template,omitempty"`
+	Vars       string              `json:"vars,omitempty" yaml:"vars,omitempty"`
+	TLS        *ScenarioTLS        `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Thresholds []string            `json:"thresholds,omitempty" yaml:"thresholds,omitempty"`
+	Output     *ScenarioOutput     `json:"output,omitempty" yaml:"output,omitempty"`
+	Agents     []string            `json:"agents,omitempty" yaml:"agents,omitempty"`
+	//Weighted request groups, replaced by -group
+	Groups []ScenarioGroup `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+//Request group with source file or requests list
+type ScenarioGroup struct {
+	Name     string              `json:"name" yaml:"name"`
+	Weight   int                 `json:"weight" yaml:"weight"`
+	Source   string              `json:"source,omitempty" yaml:"source,omitempty"`
+	Requests []meter.SourceEntry `json:"requests,omitempty" yaml:"requests,omitempty"`
+}
+
+type ScenarioStage struct {
+	Duration string `json:"duration" yaml:"duration"`
+	Rate     int    `json:"rate" yaml:"rate"`
+	//Target connections, 0 for all connections
+	Connections int `json:"connections,omitempty" yaml:"connections,omitempty"`
+}
+
+type ScenarioHAR struct {
+	Hosts        []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Path         string   `json:"path,omitempty" yaml:"path,omitempty"`
+	Methods      []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	StripCookies bool     `json:"strip_cookies,omitempty" yaml:"strip_cookies,omitempty"`
+}
+
+type ScenarioTLS struct {
+	ServerName string   `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	Insecure   bool     `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	CA         string   `json:"ca,omitempty" yaml:"ca,omitempty"`
+	Cert       string   `json:"cert,omitempty" yaml:"cert,omitempty"`
+	Key        string   `json:"key,omitempty" yaml:"key,omitempty"`
+	MinVersion string   `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	MaxVersion string   `json:"max_version,omitempty" yaml:"max_version,omitempty"`
+	Ciphers    []string `json:"ciphers,omitempty" yaml:"ciphers,omitempty"`
+}
+
+type ScenarioOutput struct {
+	//Report format: text, json
+	Format  string `json:"format,omitempty" yaml:"format,omitempty"`
+	File    string `json:"file,omitempty" yaml:"file,omitempty"`
+	CSV     string `json:"csv,omitempty" yaml:"csv,omitempty"`
+	Metrics string `json:"metrics_addr,omitempty" yaml:"metrics_addr,omitempty"`
+}
+
+//Flag value of scenario key, empty value is not set
+type scenarioFlag struct {
+	name  string
+	value string
+}
+
+//Load YAML or JSON scenario, unknown keys are errors
+func LoadScenario(fileName string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	result := &Scenario{}
+	//JSON is parsed by YAML parser too
+	if err = yaml.UnmarshalStrict(data, result); err != nil {
+		return nil, err
+	}
+	if result.Source != "" && len(result.Requests) > 0 {
+		return nil, fmt.Errorf("source and requests can not be used together")
+	}
+	for _, group := range result.Groups {
+		if group.Source != "" && len(group.Requests) > 0 {
+			return nil, fmt.Errorf("group %s source and requests can not be used together", group.Name)
+		}
+	}
+	return result, nil
+}
+
+//Set flags not set in command line from scenario
+func (this *Scenario) Apply() error {
+	set := setFlags()
+	for _, f := range this.flags() {
+		if set[f.name] || f.value == "" {
+			continue
+		}
+		if err := flag.Set(f.name, f.value); err != nil {
+			return fmt.Errorf("invalid value %q of -%s: %v", f.value, f.name, err)
+		}
+	}
+	for key, value := range this.Headers {
+		name, value, err := meter.ParseHeader(key + ": " + value)
+		if err != nil {
+			return err
+		}
+		if _, ok := _headers[name]; !ok {
+			_headers[name] = []string{value}
+		}
+	}
+	return nil
+}
+
+//Scenario values as flags, thresholds are repeated flags
+func (this *Scenario) flags() []scenarioFlag {
+	result := []scenarioFlag{
+		{"u", this.URL},
+		{"m", this.Method},
+		{"c", intValue(this.Connections)},
+		{"t", intValue(this.Threads)},
+		{"mrq", intValue(this.MRQ)},
+		{"rate", intValue(this.Rate)},
+		{"d", this.Duration},
+		{"n", intValue(this.RequestsLimit)},
+		{"per-conn", intValue(this.PerConnection)},
+		{"reconnect", boolValue(this.Reconnect)},
+		{"connect-timeout", this.ConnectTimeout},
+		{"write-timeout", this.WriteTimeout},
+		{"ttfb-timeout", this.TTFBTimeout},
+		{"timeout", this.Timeout},
+		{"drain-timeout", this.DrainTimeout},
+		{"v", boolValue(this.Verbose)},
+		{"precision", intValue(this.Precision)},
+		{"es", this.Exclude},
+		{"headers", this.HeadersFile},
+		{"s", this.Source},
+		{"template", boolValue(this.Template)},
+		{"vars", this.Vars},
+		{"agents", strings.Join(this.Agents, ",")},
+	}
+	var stages []string
+	for _, stage := range this.Stages {
+		value := fmt.Sprintf("%s:%d", stage.Duration, stage.Rate)
+		if stage.Connections > 0 {
+			value += fmt.Sprintf(":%dc", stage.Connections)
+		}
+		stages = append(stages, value)
+	}
+	result = append(result, scenarioFlag{"stages", strings.Join(stages, ",")})
+	if har := this.HAR; har != nil {
+		result = append(result,
+			scenarioFlag{"har-host", strings.Join(har.Hosts, ",")},
+			scenarioFlag{"har-path", har.Path},
+			scenarioFlag{"har-method", strings.Join(har.Methods, ",")},
+			scenarioFlag{"har-strip-cookies", boolValue(har.StripCookies)},
+		)
+	}
+	if tls := this.TLS; tls != nil {
+		result = append(result,
+			scenarioFlag{"tls-sni", tls.ServerName},
+			scenarioFlag{"tls-insecure", boolValue(tls.Insecure)},
+			scenarioFlag{"tls-ca", tls.CA},
+			scenarioFlag{"tls-cert", tls.Cert},
+			scenarioFlag{"tls-key", tls.Key},
+			scenarioFlag{"tls-min", tls.MinVersion},
+			scenarioFlag{"tls-max", tls.MaxVersion},
+			scenarioFlag{"tls-ciphers", strings.Join(tls.Ciphers, ",")},
+		)
+	}
+	if output := this.Output; output != nil {
+		result = append(result,
+			scenarioFlag{"o", output.Format},
+			scenarioFlag{"out", output.File},
+			scenarioFlag{"csv", output.CSV},
+			scenarioFlag{"metrics-addr", output.Metrics},
+		)
+	}
+	for _, threshold := range this.Thresholds {
+		result = append(result, scenarioFlag{"assert", threshold})
+	}
+	return result
+}
+
+//Effective scenario of flags, requests are kept from scenario file unless -s is set
+func flagsScenario(file *Scenario) (*Scenario, error) {
+	result := &Scenario{
+		URL:            *_url,
+		Method:         strings.ToUpper(*_method),
+		Connections:    *_connection,
+		Threads:        *_threads,
+		MRQ:            *_mrq,
+		Rate:           *_rate,
+		Duration:       durationValue(*_duration),
+		RequestsLimit:  *_requests,
+		PerConnection:  *_perConnection,
+		Reconnect:      *_reconnect,
+		ConnectTimeout: durationValue(*_connectTimeout),
+		WriteTimeout:   durationValue(*_writeTimeout),
+		TTFBTimeout:    durationValue(*_ttfbTimeout),
+		Timeout:        durationValue(*_timeout),
+		//Zero drain timeout is not default
+		DrainTimeout: (*_drainTimeout).String(),
+		Verbose:      *_verbose,
+		Precision:    *_precision,
+		Exclude:      durationValue(*_excludeSeconds),
+		HeadersFile:  *_headersFile,
+		Source:       *_source,
+		Template:     *_template,
+		Vars:         *_vars,
+		Agents:       meter.SplitList(*_agents),
+	}
+	//Request budget without -d is not limited by time
+	if (*_requests > 0 || *_perConnection > 0) && !isFlagSet("d") {
+		result.Duration = ""
+	}
+	stages, err := meter.ParseStages(*_stages)
+	if err != nil {
+		return nil, err
+	}
+	for _, stage := range stages {
+		result.Stages = append(result.Stages, ScenarioStage{Duration: stage.Duration.String(), Rate: stage.Rate, Connections: stage.Connections})
+	}
+	if len(_headers) > 0 {
+		result.Headers = map[string]string{}
+		for key, values := range _headers {
+			result.Headers[key] = strings.Join(values, ", ")
+		}
+	}
+	if file != nil && *_source == "" {
+		result.Requests = file.Requests
+	}
+	if len(_groups) > 0 {
+		for _, group := range _groups {
+			result.Groups = append(result.Groups, ScenarioGroup{Name: group.Name, Weight: group.Weight, Source: group.Source})
+		}
+	} else if file != nil {
+		result.Groups = file.Groups
+	}
+	if *_harHost != "" || *_harPath != "" || *_harMethod != "" || *_harNoCookies {
+		result.HAR = &ScenarioHAR{
+			Hosts:        meter.SplitList(*_harHost),
+			Path:         *_harPath,
+			Methods:      meter.SplitList(*_harMethod),
+			StripCookies: *_harNoCookies,
+		}
+	}
+	if *_tlsServerName != "" || *_tlsInsecure || *_tlsCA != "" || *_tlsCert != "" || *_tlsKey != "" || *_tlsMin != "" || *_tlsMax != "" || *_tlsCiphers != "" {
+		result.TLS = &ScenarioTLS{
+			ServerName: *_tlsServerName,
+			Insecure:   *_tlsInsecure,
+			CA:         *_tlsCA,
+			Cert:       *_tlsCert,
+			Key:        *_tlsKey,
+			MinVersion: *_tlsMin,
+			MaxVersion: *_tlsMax,
+			Ciphers:    meter.SplitList(*_tlsCiphers),
+		}
+	}
+	for _, threshold := range _thresholds {
+		result.Thresholds = append(result.Thresholds, threshold.Expr)
+	}
+	output := &ScenarioOutput{
+		Format:  *_output,
+		File:    *_outFile,
+		CSV:     *_csvFile,
+		Metrics: *_metricsAddr,
+	}
+	if *output != (ScenarioOutput{}) {
+		result.Output = output
+	}
+	return result, nil
+}
+
+//Print scenario in YAML or JSON format
+func (this *Scenario) Print(w io.Writer, format string) error {
+	var (
+		data []byte
+		err  error
+	)
+	if format == "json" {
+		data, err = json.MarshalIndent(this, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(this)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+//Scenario format by file extension, YAML by default
+func scenarioFormat(fileName string) string {
+	if strings.HasSuffix(fileName, ".json") {
+		return "json"
+	}
+	return "yaml"
+}
+
+//Names of flags set in command line or by scenario
+func setFlags() map[string]bool {
+	result := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		result[f.Name] = true
+	})
+	return result
+}
+
+func isFlagSet(name string) bool {
+	return setFlags()[name]
+}
+
+func intValue(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+func boolValue(value bool) string {
+	if !value {
+		return ""
+	}
+	return "true"
+}
+
+func durationValue(value time.Duration) string {
+	if value == 0 {
+		return ""
+	}
+	return value.String()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Parse arguments to command line flags with default values, flags of previous parse are not set
+func parseFlags(t *testing.T, args ...string) {
+	commandLine := flag.CommandLine
+	t.Cleanup(func() {
+		flag.CommandLine = commandLine
+	})
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		switch {
+		//Values of test binary flags are kept
+		case strings.HasPrefix(f.Name, "test."):
+		case f.Name == "H" || f.Name == "assert" || f.Name == "group" || f.Name == "search-slo":
+		default:
+			f.Value.Set(f.DefValue)
+		}
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	for key := range _headers {
+		delete(_headers, key)
+	}
+	_thresholds, _groups, _searchSLO = nil, nil, nil
+	flag.CommandLine = flags
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//Write file to test temp dir, returns file name
+func writeFile(t *testing.T, name, data string) string {
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadScenario(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"unknown key", "url: http://service/\nconnection: 10\n", "connection"},
+		{"unknown json key", `{"url": "http://service/", "tls": {"verify": false}}`, "verify"},
+		{"source and requests", "source: bodies.txt\nrequests:\n  - url: /items\n", "source and requests"},
+		{"group source and requests", "groups:\n  - name: search\n    weight: 1\n    source: search.txt\n    requests:\n      - url: /search\n", "group search"},
+	}
+	for _, test := range tests {
+		fileName := writeFile(t, "scenario.yaml", test.data)
+		if _, err := LoadScenario(fileName); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	scenario, err := LoadScenario(writeFile(t, "scenario.json", `{"url": "http://service/", "connections": 10, "stages": [{"duration": "10s", "rate": 100}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.URL != "http://service/" || scenario.Connections != 10 || !reflect.DeepEqual(scenario.Stages, []ScenarioStage{{Duration: "10s", Rate: 100}}) {
+		t.Errorf("scenario %+v", scenario)
+	}
+	if _, err = LoadScenario("not-exists.yaml"); err == nil {
+		t.Error("missing file: expected error")
+	}
+}
+
+func TestScenarioApply(t *testing.T) {
+	headersFile := writeFile(t, "headers.txt", "X-A: file\nX-B: file\nX-C: file\n")
+	parseFlags(t, "-c", "8", "-H", "X-A: flag", "-assert", "p99<1s")
+	scenario := &Scenario{
+		Connections: 100,
+		Threads:     3,
+		Duration:    "5s",
+		Headers:     map[string]string{"X-A": "scenario", "x-b": "scenario"},
+		HeadersFile: headersFile,
+		Thresholds:  []string{"errors<1%"},
+	}
+	if err := scenario.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	//Flags win, scenario sets not set flags
+	if *_connection != 8 || *_threads != 3 || *_duration != 5*time.Second || *_headersFile != headersFile {
+		t.Errorf("connections %d, threads %d, duration %v, headers file %q", *_connection, *_threads, *_duration, *_headersFile)
+	}
+	if len(_thresholds) != 1 || _thresholds[0].Expr != "p99<1s" {
+		t.Errorf("thresholds %v", _thresholds)
+	}
+	//-H beats scenario headers, scenario headers beat headers file
+	header, err := requestHeaders(*_headersFile, _headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]string{"X-A": {"flag"}, "X-B": {"scenario"}, "X-C": {"file"}}; !reflect.DeepEqual(header, expected) {
+		t.Errorf("headers %v", header)
+	}
+
+	parseFlags(t)
+	if err = (&Scenario{Duration: "5x"}).Apply(); err == nil || !strings.Contains(err.Error(), "-d") {
+		t.Errorf("invalid duration: error %v", err)
+	}
+	parseFlags(t)
+	if err = (&Scenario{Headers: map[string]string{"Content-Length": "5"}}).Apply(); err == nil {
+		t.Error("content length header: expected error")
+	}
+}
+
+func TestFlagsScenario(t *testing.T) {
+	bodies := writeFile(t, "bodies.txt", "a\nb\n")
+	data := `url: http://service/items
+method: post
+connections: 10
+rate: 200
+requests_limit: 1000
+ttfb_timeout: 1s
+drain_timeout: 0s
+headers:
+  Accept: '*/*'
+source: ` + bodies + `
+stages:
+  - duration: 10s
+    rate: 100
+  - duration: 20s
+    rate: 500
+    connections: 5
+tls:
+  insecure: true
+  ciphers: [TLS_AES_128_GCM_SHA256]
+thresholds: ["p99<200ms", "errors<1%"]
+output:
+  format: json
+groups:
+  - name: search
+    weight: 3
+    requests:
+      - url: /search?q=a
+`
+	//Dump of scenario and flags is loaded to the same effective scenario
+	var dumps []*Scenario
+	for _, format := range []string{"yaml", "json"} {
+		fileName := writeFile(t, "scenario.yaml", data)
+		for i := 0; i < 2; i++ {
+			parseFlags(t, "-c", "20", "-H", "X-Tag: a")
+			file, err := LoadScenario(fileName)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if err = file.Apply(); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			scenario, err := flagsScenario(file)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			dumps = append(dumps, scenario)
+			buf := &bytes.Buffer{}
+			if err = scenario.Print(buf, format); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			fileName = writeFile(t, "dump."+format, buf.String())
+		}
+	}
+	scenario := dumps[0]
+	if scenario.Method != "POST" || scenario.Connections != 20 || scenario.Threads != 4 || scenario.RequestsLimit != 1000 ||
+		scenario.Duration != "" || scenario.DrainTimeout != "0s" || scenario.TTFBTimeout != "1s" || scenario.Source != bodies ||
+		len(scenario.Stages) != 2 || scenario.Stages[1] != (ScenarioStage{Duration: "20s", Rate: 500, Connections: 5}) ||
+		len(scenario.Groups) != 1 || len(scenario.Groups[0].Requests) != 1 || len(scenario.Thresholds) != 2 ||
+		scenario.TLS == nil || !scenario.TLS.Insecure || scenario.Output == nil || scenario.Output.Format != "json" {
+		t.Errorf("scenario %+v", scenario)
+	}
+	if expected := map[string]string{"Accept": "*/*", "X-Tag": "a"}; !reflect.DeepEqual(scenario.Headers, expected) {
+		t.Errorf("headers %v", scenario.Headers)
+	}
+	for i, dump := range dumps[1:] {
+		if !reflect.DeepEqual(dump, scenario) {
+			t.Errorf("dump %d:\n%+v\nwant:\n%+v", i+1, dump, scenario)
+		}
+	}
+}
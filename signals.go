@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+//Signal of live stats snapshot, other control signal resets stats
+var snapshotSignal os.Signal = syscall.SIGUSR1
+
+func notifyControl(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+}
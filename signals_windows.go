@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+//There are no user signals on Windows, snapshot and reset are disabled
+var snapshotSignal os.Signal
+
+func notifyControl(c chan os.Signal) {
+}